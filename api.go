@@ -2,6 +2,8 @@ package api
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/xml"
 	"fmt"
 	"io"
@@ -14,172 +16,473 @@ const (
 	HeaderAuthorizationKey      = "Authorization"
 	HeaderIfModifiedSinceKey    = "If-Modified-Since"
 	HeaderTokenKey              = "Token"
+	HeaderUserAgentKey          = "User-Agent"
 	HeaderTokenAuthFormatString = "Basic %s"
 )
 
+// TokenStorage persists the Api's token between runs. Implementations should keep
+// the token's AcquiredAt and ExpiresAt and hand them back through RestoreToken, so
+// an expired token is renewed instead of being sent to the API.
 type TokenStorage interface {
 	Save(token Token) error
 	Load() (*Token, error)
 }
 
+// Api is a client for the Vebra v10 API. It is safe for concurrent use by
+// multiple goroutines; all of them share one token, which is renewed once when
+// the API rejects it no matter how many requests were refused at the same time.
 type Api struct {
-	dataFeedId   string
-	credentials  *credentials
-	tokenStorage TokenStorage
-	Error        error
-	StatusCode   int
-}
-
-func NewApi(dataFeedId string, username string, password string) *Api {
-	return &Api{
+	dataFeedId       string
+	credentials      *credentials
+	tokens           *tokenCache
+	httpClient       *http.Client
+	transport        http.RoundTripper
+	baseURL          string
+	userAgent        string
+	timeout          time.Duration
+	retryPolicy      RetryPolicy
+	limiter          *rateLimiter
+	tokenLimiter     *rateLimiter
+	responseCache    ResponseCache
+	responseCacheTTL time.Duration
+	logger           Logger
+	metrics          *Metrics
+	tracer           Tracer
+}
+
+func NewApi(dataFeedId string, username string, password string, options ...Option) *Api {
+	api := &Api{
 		dataFeedId: dataFeedId,
 		credentials: &credentials{
 			userName: username,
 			password: password,
 		},
+		tokens:      newTokenCache(),
+		httpClient:  &http.Client{},
+		retryPolicy: DefaultRetryPolicy(),
 	}
+	api.tokens.discarded = api.logTokenDiscarded
+	for _, option := range options {
+		option(api)
+	}
+	if api.transport != nil {
+		client := *api.httpClient
+		client.Transport = api.transport
+		api.httpClient = &client
+	}
+	return api
 }
 
 func (api *Api) SetTokenStorage(tokenStorage TokenStorage) {
-	api.tokenStorage = tokenStorage
+	api.tokens.setStorage(tokenStorage)
 }
 
 // GetBranches returns an array of branches
 func (api *Api) GetBranches() (*BranchSummaries, error) {
+	return api.GetBranchesContext(context.Background())
+}
+
+// GetBranchesContext is like GetBranches but aborts the request when ctx is done
+func (api *Api) GetBranchesContext(ctx context.Context) (*BranchSummaries, error) {
+	branches, _, err := api.getBranches(ctx, nil)
+	return branches, err
+}
+
+// GetBranchesIfModifiedSince returns the branches unless they are unchanged since the given time,
+// in which case notModified is true and branches comes from the response cache, or is nil without one.
+func (api *Api) GetBranchesIfModifiedSince(since time.Time) (branches *BranchSummaries, notModified bool, err error) {
+	return api.GetBranchesIfModifiedSinceContext(context.Background(), since)
+}
+
+// GetBranchesIfModifiedSinceContext is like GetBranchesIfModifiedSince but aborts the request when ctx is done
+func (api *Api) GetBranchesIfModifiedSinceContext(ctx context.Context, since time.Time) (branches *BranchSummaries, notModified bool, err error) {
+	return api.getBranches(ctx, &since)
+}
+
+func (api *Api) getBranches(ctx context.Context, since *time.Time) (*BranchSummaries, bool, error) {
 	branches := new(BranchSummaries)
 	branchesURLBuilder := new(URLGetBranchesBuilder)
+	branchesURLBuilder.SetBaseURL(api.baseURL)
 	branchesURLBuilder.SetDataFeedID(api.dataFeedId)
-	if err := api.doRequest(branchesURLBuilder, branches); err != nil {
-		return nil, err
+	result, err := api.doRequestSince(ctx, branchesURLBuilder, branches, since)
+	if err != nil || !result.decoded() {
+		return nil, result.notModified(), err
 	}
-	return branches, nil
+	return branches, result.notModified(), nil
 }
 
 func (api *Api) GetBranch(branchSummary *BranchSummary) (branch *Branch, err error) {
-	branch = new(Branch)
+	return api.GetBranchContext(context.Background(), branchSummary)
+}
+
+// GetBranchContext is like GetBranch but aborts the request when ctx is done
+func (api *Api) GetBranchContext(ctx context.Context, branchSummary *BranchSummary) (branch *Branch, err error) {
+	branch, _, err = api.getBranch(ctx, branchSummary, nil)
+	return branch, err
+}
+
+// GetBranchIfModifiedSince returns the branch unless it is unchanged since the given time,
+// in which case notModified is true and branch comes from the response cache, or is nil without one.
+func (api *Api) GetBranchIfModifiedSince(branchSummary *BranchSummary, since time.Time) (branch *Branch, notModified bool, err error) {
+	return api.GetBranchIfModifiedSinceContext(context.Background(), branchSummary, since)
+}
+
+// GetBranchIfModifiedSinceContext is like GetBranchIfModifiedSince but aborts the request when ctx is done
+func (api *Api) GetBranchIfModifiedSinceContext(ctx context.Context, branchSummary *BranchSummary, since time.Time) (branch *Branch, notModified bool, err error) {
+	return api.getBranch(ctx, branchSummary, &since)
+}
+
+func (api *Api) getBranch(ctx context.Context, branchSummary *BranchSummary, since *time.Time) (*Branch, bool, error) {
+	branch := new(Branch)
 	branchURLBuilder := new(URLGetBranchBuilder)
+	branchURLBuilder.SetBaseURL(api.baseURL)
 	branchURLBuilder.SetDataFeedID(api.dataFeedId)
 	branchURLBuilder.SetClientID(branchSummary.GetClientIDString())
-	if err := api.doRequest(branchURLBuilder, branch); err != nil {
-		return nil, err
+	result, err := api.doRequestSince(ctx, branchURLBuilder, branch, since)
+	if err != nil || !result.decoded() {
+		return nil, result.notModified(), err
 	}
-	return branch, nil
+	return branch, result.notModified(), nil
 }
 
 func (api *Api) GetProperties(branchSummary *BranchSummary) (properties *PropertySummaries, err error) {
-	properties = new(PropertySummaries)
+	return api.GetPropertiesContext(context.Background(), branchSummary)
+}
+
+// GetPropertiesContext is like GetProperties but aborts the request when ctx is done
+func (api *Api) GetPropertiesContext(ctx context.Context, branchSummary *BranchSummary) (properties *PropertySummaries, err error) {
+	properties, _, err = api.getProperties(ctx, branchSummary, nil)
+	return properties, err
+}
+
+// GetPropertiesIfModifiedSince returns the branch's properties unless they are unchanged since the given time,
+// in which case notModified is true and properties comes from the response cache, or is nil without one.
+func (api *Api) GetPropertiesIfModifiedSince(branchSummary *BranchSummary, since time.Time) (properties *PropertySummaries, notModified bool, err error) {
+	return api.GetPropertiesIfModifiedSinceContext(context.Background(), branchSummary, since)
+}
+
+// GetPropertiesIfModifiedSinceContext is like GetPropertiesIfModifiedSince but aborts the request when ctx is done
+func (api *Api) GetPropertiesIfModifiedSinceContext(ctx context.Context, branchSummary *BranchSummary, since time.Time) (properties *PropertySummaries, notModified bool, err error) {
+	return api.getProperties(ctx, branchSummary, &since)
+}
+
+func (api *Api) getProperties(ctx context.Context, branchSummary *BranchSummary, since *time.Time) (*PropertySummaries, bool, error) {
+	properties := new(PropertySummaries)
 	propertiesURLBuilder := new(URLGetPropertiesBuilder)
+	propertiesURLBuilder.SetBaseURL(api.baseURL)
 	propertiesURLBuilder.SetDataFeedID(api.dataFeedId)
 	propertiesURLBuilder.SetClientID(branchSummary.GetClientIDString())
-	if err := api.doRequest(propertiesURLBuilder, properties); err != nil {
-		return nil, err
+	result, err := api.doRequestSince(ctx, propertiesURLBuilder, properties, since)
+	if err != nil || !result.decoded() {
+		return nil, result.notModified(), err
 	}
-	return properties, nil
+	return properties, result.notModified(), nil
 }
 
 func (api *Api) GetProperty(branchSummary *BranchSummary, summary PropertySummary) (property *Property, err error) {
-	property = new(Property)
+	return api.GetPropertyContext(context.Background(), branchSummary, summary)
+}
+
+// GetPropertyContext is like GetProperty but aborts the request when ctx is done
+func (api *Api) GetPropertyContext(ctx context.Context, branchSummary *BranchSummary, summary PropertySummary) (property *Property, err error) {
+	property, _, err = api.getProperty(ctx, branchSummary, summary, nil)
+	return property, err
+}
+
+// GetPropertyIfModifiedSince returns the property unless it is unchanged since the given time,
+// in which case notModified is true and property comes from the response cache, or is nil without one.
+func (api *Api) GetPropertyIfModifiedSince(branchSummary *BranchSummary, summary PropertySummary, since time.Time) (property *Property, notModified bool, err error) {
+	return api.GetPropertyIfModifiedSinceContext(context.Background(), branchSummary, summary, since)
+}
+
+// GetPropertyIfModifiedSinceContext is like GetPropertyIfModifiedSince but aborts the request when ctx is done
+func (api *Api) GetPropertyIfModifiedSinceContext(ctx context.Context, branchSummary *BranchSummary, summary PropertySummary, since time.Time) (property *Property, notModified bool, err error) {
+	return api.getProperty(ctx, branchSummary, summary, &since)
+}
+
+func (api *Api) getProperty(ctx context.Context, branchSummary *BranchSummary, summary PropertySummary, since *time.Time) (*Property, bool, error) {
+	property := new(Property)
 	propertyURLBuilder := new(URLGetPropertyBuilder)
+	propertyURLBuilder.SetBaseURL(api.baseURL)
 	propertyURLBuilder.SetDataFeedID(api.dataFeedId)
 	propertyURLBuilder.SetClientID(branchSummary.GetClientIDString())
 	propertyURLBuilder.SetPropertyID(strconv.Itoa(int(summary.PropertyID)))
-	if err := api.doRequest(propertyURLBuilder, property); err != nil {
-		return nil, err
+	result, err := api.doRequestSince(ctx, propertyURLBuilder, property, since)
+	if err != nil || !result.decoded() {
+		return nil, result.notModified(), err
 	}
-	return property, nil
+	return property, result.notModified(), nil
 }
 
 func (api *Api) GetPropertyFromChangedFileSummary(summary ChangedFileSummary) (property *Property, err error) {
-	property = new(Property)
-	propertyURLBuilder := new(ChangedPropertyURLBuilder)
-	propertyURLBuilder.SetURL(summary.PropUrl)
-	if err := api.doRequest(propertyURLBuilder, property); err != nil {
-		return nil, err
-	}
-	return property, nil
+	return api.GetPropertyFromChangedFileSummaryContext(context.Background(), summary)
+}
+
+// GetPropertyFromChangedFileSummaryContext is like GetPropertyFromChangedFileSummary
+// but aborts the request when ctx is done
+func (api *Api) GetPropertyFromChangedFileSummaryContext(ctx context.Context, summary ChangedFileSummary) (property *Property, err error) {
+	property, _, err = api.getPropertyByURL(ctx, summary.PropUrl, nil)
+	return property, err
 }
 
 func (api *Api) GetChangedProperties(since time.Time) (properties *ChangedPropertySummaries, err error) {
+	return api.GetChangedPropertiesContext(context.Background(), since)
+}
+
+// GetChangedPropertiesContext is like GetChangedProperties but aborts the request when ctx is done
+func (api *Api) GetChangedPropertiesContext(ctx context.Context, since time.Time) (properties *ChangedPropertySummaries, err error) {
 	properties = new(ChangedPropertySummaries)
 	propertiesURLBuilder := new(URLGetChangedPropertiesBuilder)
+	propertiesURLBuilder.SetBaseURL(api.baseURL)
 	propertiesURLBuilder.SetDataFeedID(api.dataFeedId)
 	propertiesURLBuilder.SetSince(since)
-	if err = api.doRequest(propertiesURLBuilder, properties); err != nil {
+	if err = api.doRequest(ctx, propertiesURLBuilder, properties); err != nil {
 		return nil, err
 	}
 	return properties, nil
 }
 
 func (api *Api) GetChangedProperty(changedProperty *ChangedPropertySummary) (property *Property, err error) {
+	return api.GetChangedPropertyContext(context.Background(), changedProperty)
+}
+
+// GetChangedPropertyContext is like GetChangedProperty but aborts the request when ctx is done
+func (api *Api) GetChangedPropertyContext(ctx context.Context, changedProperty *ChangedPropertySummary) (property *Property, err error) {
 	if changedProperty.LastAction == Deleted {
-		return nil, fmt.Errorf("property [%s] has been deleted", strconv.Itoa(int(changedProperty.PropertyID)))
+		return nil, fmt.Errorf("%w: [%s]", ErrPropertyDeleted, strconv.Itoa(int(changedProperty.PropertyID)))
 	}
-	property = new(Property)
-	propertiesURLBuilder := new(ChangedPropertyURLBuilder)
-	propertiesURLBuilder.SetURL(changedProperty.Url)
-	if err = api.doRequest(propertiesURLBuilder, property); err != nil {
-		return nil, err
+	property, _, err = api.getPropertyByURL(ctx, changedProperty.Url, nil)
+	return property, err
+}
+
+// GetChangedPropertyIfModifiedSince returns the changed property unless it is unchanged since the given time,
+// in which case notModified is true and property comes from the response cache, or is nil without one.
+func (api *Api) GetChangedPropertyIfModifiedSince(changedProperty *ChangedPropertySummary, since time.Time) (property *Property, notModified bool, err error) {
+	return api.GetChangedPropertyIfModifiedSinceContext(context.Background(), changedProperty, since)
+}
+
+// GetChangedPropertyIfModifiedSinceContext is like GetChangedPropertyIfModifiedSince but aborts the request when ctx is done
+func (api *Api) GetChangedPropertyIfModifiedSinceContext(ctx context.Context, changedProperty *ChangedPropertySummary, since time.Time) (property *Property, notModified bool, err error) {
+	if changedProperty.LastAction == Deleted {
+		return nil, false, fmt.Errorf("%w: [%s]", ErrPropertyDeleted, strconv.Itoa(int(changedProperty.PropertyID)))
+	}
+	return api.getPropertyByURL(ctx, changedProperty.Url, &since)
+}
+
+func (api *Api) getPropertyByURL(ctx context.Context, url string, since *time.Time) (*Property, bool, error) {
+	property := new(Property)
+	propertyURLBuilder := new(ChangedPropertyURLBuilder)
+	propertyURLBuilder.SetBaseURL(api.baseURL)
+	propertyURLBuilder.SetURL(url)
+	result, err := api.doRequestSince(ctx, propertyURLBuilder, property, since)
+	if err != nil || !result.decoded() {
+		return nil, result.notModified(), err
 	}
-	return property, nil
+	return property, result.notModified(), nil
 }
 
 func (api *Api) GetChangedFiles(since time.Time) (changedFiles *ChangedFilesSummaries, err error) {
+	return api.GetChangedFilesContext(context.Background(), since)
+}
+
+// GetChangedFilesContext is like GetChangedFiles but aborts the request when ctx is done
+func (api *Api) GetChangedFilesContext(ctx context.Context, since time.Time) (changedFiles *ChangedFilesSummaries, err error) {
 	changedFiles = new(ChangedFilesSummaries)
 	urlGetChangedFilesBuilder := new(URLGetChangedFilesBuilder)
+	urlGetChangedFilesBuilder.SetBaseURL(api.baseURL)
 	urlGetChangedFilesBuilder.SetDataFeedID(api.dataFeedId)
 	urlGetChangedFilesBuilder.SetSince(since)
-	if err = api.doRequest(urlGetChangedFilesBuilder, changedFiles); err != nil {
+	if err = api.doRequest(ctx, urlGetChangedFilesBuilder, changedFiles); err != nil {
 		return nil, err
 	}
 	return changedFiles, nil
 }
 
-func (api *Api) doRequest(urlBuilder URLBuilder, out interface{}) (err error) {
+// requestResult tells how out was filled by doRequestSince
+type requestResult int
+
+const (
+	// resultFetched means out was decoded from a 200 response
+	resultFetched requestResult = iota
+	// resultNotModified means the API answered 304 and out was left untouched
+	resultNotModified
+	// resultFromCache means the API answered 304 and out was decoded from the response cache
+	resultFromCache
+)
+
+func (result requestResult) notModified() bool {
+	return result != resultFetched
+}
+
+func (result requestResult) decoded() bool {
+	return result != resultNotModified
+}
+
+// cachedResult tells whether a response served from the cache counts as not
+// modified since the time the caller asked about
+func cachedResult(cachedResponse *CachedResponse, since *time.Time) requestResult {
+	if since != nil && !cachedResponse.LastModifiedTime().After(*since) {
+		return resultFromCache
+	}
+	return resultFetched
+}
+
+func (api *Api) doRequest(ctx context.Context, urlBuilder URLBuilder, out interface{}) (err error) {
+	_, err = api.doRequestSince(ctx, urlBuilder, out, nil)
+	return err
+}
+
+func (api *Api) doRequestSince(ctx context.Context, urlBuilder URLBuilder, out interface{}, since *time.Time) (result requestResult, err error) {
+	ctx, span := startSpan(ctx, api.tracer, "vebra "+endpointLabel(urlBuilder.Build()), urlAttributes(urlBuilder.Build())...)
+	defer func() {
+		span.SetAttributes(AttributeNotModified.Bool(result.notModified()))
+		span.End(err)
+	}()
+	if api.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, api.timeout)
+		defer cancel()
+	}
 	requestor := buildRequestor(api.dataFeedId, api.credentials)
-	if api.tokenStorage != nil {
-		if requestor.token, err = api.tokenStorage.Load(); err != nil {
-			return err
+	requestor.client = api.httpClient
+	requestor.cache = api.responseCache
+	requestor.urlBuilder = urlBuilder
+	if cachedResponse, ok := requestor.cachedResponse(); ok {
+		if api.responseCacheTTL > 0 && time.Since(cachedResponse.StoredAt) < api.responseCacheTTL {
+			return cachedResult(cachedResponse, since), api.unmarshalCached(ctx, requestor, cachedResponse, out)
 		}
+		requestor.revalidate(cachedResponse)
+	} else {
+		requestor.setIfModifiedSince(since)
 	}
-	requestor.urlBuilder = urlBuilder
-	for ; requestor.attempts < 2; requestor.attempts++ {
-		requestor.buildRequest()
-		requestor.doRequest()
-		requestor.saveTokenIfExists(api.tokenStorage)
-		requestor.handleErrors()
-		switch requestor.response.StatusCode {
-		case http.StatusOK:
-			return requestor.unmarshal(out)
-		case http.StatusNotModified:
-			return nil
+	if api.userAgent != "" {
+		requestor.setHeaderAttribute(HeaderUserAgentKey, api.userAgent)
+	}
+	tokenRejected := false
+	failedAttempts := 0
+	for {
+		if err = ctx.Err(); err != nil {
+			return resultFetched, err
+		}
+		requestor.discardResponse()
+		requestor.attempts++
+		err = api.authenticatedRequest(ctx, requestor)
+		if err == nil {
+			span.SetAttributes(AttributeStatusCode.Int(requestor.response.StatusCode))
+			switch requestor.response.StatusCode {
+			case http.StatusOK:
+				return resultFetched, api.unmarshal(ctx, requestor, out)
+			case http.StatusNotModified:
+				if cachedResponse := requestor.revalidated(); cachedResponse != nil {
+					requestor.discardResponse()
+					return cachedResult(cachedResponse, since), api.unmarshalCached(ctx, requestor, cachedResponse, out)
+				}
+				if since == nil {
+					// nothing was compared against, so there is nothing to decode either
+					return resultFetched, newAPIError(requestor.response)
+				}
+				requestor.discardResponse()
+				return resultNotModified, nil
+			case http.StatusUnauthorized:
+				// the token was rejected, try once more with a renewed one
+				if !tokenRejected {
+					tokenRejected = true
+					continue
+				}
+			}
+		}
+		failedAttempts++
+		if !api.retryPolicy.shouldRetry(failedAttempts, requestor.response, err) {
+			break
+		}
+		event := RetryEvent{
+			Attempt: failedAttempts,
+			URL:     requestor.request.URL.String(),
+			Err:     err,
+			Delay:   api.retryPolicy.delay(failedAttempts, requestor.response),
+		}
+		if requestor.response != nil {
+			event.StatusCode = requestor.response.StatusCode
+		}
+		api.logRetry(ctx, event)
+		if err = api.retryPolicy.wait(ctx, event); err != nil {
+			requestor.discardResponse()
+			return resultFetched, err
 		}
 	}
-	api.StatusCode = requestor.response.StatusCode
-	api.Error = fmt.Errorf(requestor.response.Status)
-	return api.Error
+	if err != nil {
+		return resultFetched, err
+	}
+	return resultFetched, newAPIError(requestor.response)
 }
 
-func (api *Api) doRequestSince(urlBuilder URLBuilder, out interface{}, since *time.Time) (err error) {
-	requestor := buildRequestor(api.dataFeedId, api.credentials)
-	requestor.setIfModifiedSince(since)
-	if api.tokenStorage != nil {
-		if requestor.token, err = api.tokenStorage.Load(); err != nil {
+// authenticatedRequest sends the request with the shared token, or with basic auth
+// when this caller has been chosen to renew the token
+func (api *Api) authenticatedRequest(ctx context.Context, requestor *requestor) (err error) {
+	token, renew, err := api.tokens.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	if renew {
+		defer api.tokens.release()
+		if err = api.tokenLimiter.wait(ctx); err != nil {
 			return err
 		}
 	}
-	requestor.urlBuilder = urlBuilder
-	for ; requestor.attempts < 2; requestor.attempts++ {
-		requestor.buildRequest()
-		requestor.doRequest()
-		requestor.saveTokenIfExists(api.tokenStorage)
-		requestor.handleErrors()
-		api.StatusCode = requestor.response.StatusCode
-		if requestor.response.StatusCode == http.StatusOK {
-			return requestor.unmarshal(out)
+	if err = api.limiter.wait(ctx); err != nil {
+		return err
+	}
+	requestor.token = &token
+	if err = requestor.buildRequest(ctx); err != nil {
+		return err
+	}
+	api.logRequestStarted(ctx, requestor)
+	started := time.Now()
+	err = requestor.doRequest()
+	api.logRequestFinished(ctx, requestor, started, err)
+	if err != nil {
+		api.metrics.observeRequest(requestor.request.URL.String(), 0, time.Since(started))
+		return err
+	}
+	api.metrics.observeRequest(requestor.request.URL.String(), requestor.response.StatusCode, time.Since(started))
+	if newToken := requestor.responseToken(); newToken != nil {
+		if err := api.tokens.store(newToken); err != nil {
+			// the token is still cached, so the response stands and only the next process has to renew it
+			api.logTokenSaveFailed(ctx, err)
 		}
+		api.logTokenAcquired(ctx, newToken)
+		api.metrics.observeTokenRenewal()
+	}
+	if requestor.response.StatusCode == http.StatusUnauthorized && token.IsValid() {
+		api.tokens.invalidate(token)
+		api.logTokenInvalidated(ctx, &token)
 	}
-	api.Error = fmt.Errorf(requestor.response.Status)
-	return api.Error
+	return nil
+}
+
+// unmarshal decodes the response body
+func (api *Api) unmarshal(ctx context.Context, requestor *requestor, out interface{}) (err error) {
+	ctx, span := startSpan(ctx, api.tracer, "vebra unmarshal", AttributeFromCache.Bool(false))
+	defer func() { span.End(err) }()
+	if err = requestor.unmarshal(out); err != nil && !isCallbackError(err) {
+		api.logUnmarshalFailed(ctx, requestor, err)
+	}
+	return err
+}
+
+// unmarshalCached decodes a response body served from the response cache
+func (api *Api) unmarshalCached(ctx context.Context, requestor *requestor, cachedResponse *CachedResponse, out interface{}) (err error) {
+	ctx, span := startSpan(ctx, api.tracer, "vebra unmarshal", AttributeFromCache.Bool(true))
+	defer func() { span.End(err) }()
+	if stream, ok := out.(streamDecoder); ok {
+		err = stream.decodeStream(xml.NewDecoder(bytes.NewReader(cachedResponse.Body)))
+	} else {
+		err = xml.Unmarshal(cachedResponse.Body, out)
+	}
+	if err != nil && !isCallbackError(err) {
+		api.logUnmarshalFailed(ctx, requestor, err)
+	}
+	return err
 }
 
 type credentials struct {
@@ -187,23 +490,49 @@ type credentials struct {
 	password string
 }
 
+// TokenLifetime is how long a token handed out by the API stays valid
+const TokenLifetime = 60 * time.Minute
+
+// DefaultTokenRenewBefore is how long before its expiry a token is renewed
+const DefaultTokenRenewBefore = 5 * time.Minute
+
 type Token struct {
 	tokenString string
 	isEmpty     bool
 	isValid     bool
 	timeSet     time.Time
+	expiresAt   time.Time
 }
 
+// NewToken returns a token acquired now, expiring after TokenLifetime
 func NewToken(tokenString string) *Token {
 	token := new(Token)
 	token.tokenString = tokenString
 	token.isValid = true
 	token.timeSet = time.Now()
+	token.expiresAt = token.timeSet.Add(TokenLifetime)
+	return token
+}
+
+// RestoreToken rebuilds a token loaded by a TokenStorage from the metadata it was saved with.
+// A zero expiresAt means the expiry is unknown and the token is used until the API rejects it.
+func RestoreToken(tokenString string, acquiredAt time.Time, expiresAt time.Time) *Token {
+	token := new(Token)
+	token.tokenString = tokenString
+	token.isValid = tokenString != ""
+	token.timeSet = acquiredAt
+	token.expiresAt = expiresAt
 	return token
 }
 
+// IsValid reports whether the token has neither been rejected by the API nor expired
 func (token *Token) IsValid() bool {
-	return token.isValid
+	return token.isValid && !token.IsExpired()
+}
+
+// IsExpired reports whether the token is past its expiry
+func (token *Token) IsExpired() bool {
+	return !token.expiresAt.IsZero() && !time.Now().Before(token.expiresAt)
 }
 
 func (token *Token) Invalidate() {
@@ -215,22 +544,49 @@ func (token *Token) GetToken() string {
 	return token.tokenString
 }
 
+// AcquiredAt returns when the API handed out the token
+func (token *Token) AcquiredAt() time.Time {
+	return token.timeSet
+}
+
+// ExpiresAt returns when the token expires, or the zero time if that is unknown
+func (token *Token) ExpiresAt() time.Time {
+	return token.expiresAt
+}
+
+// Age returns how long ago the token was acquired
+func (token *Token) Age() time.Duration {
+	if token.timeSet.IsZero() {
+		return 0
+	}
+	return time.Since(token.timeSet)
+}
+
+// expiresWithin reports whether the token expires less than d from now
+func (token *Token) expiresWithin(d time.Duration) bool {
+	return !token.expiresAt.IsZero() && time.Until(token.expiresAt) < d
+}
+
 type requestor struct {
-	dataFeedID  string
-	credentials *credentials
-	token       *Token
-	urlBuilder  URLBuilder
-	header      http.Header
-	since       *time.Time
-	request     *http.Request
-	response    *http.Response
-	body        io.Reader
-	err         error
-	attempts    int
+	client       *http.Client
+	cache        ResponseCache
+	revalidating *CachedResponse
+	dataFeedID   string
+	credentials  *credentials
+	token        *Token
+	urlBuilder   URLBuilder
+	header       http.Header
+	since        *time.Time
+	request      *http.Request
+	response     *http.Response
+	body         io.Reader
+	err          error
+	attempts     int
 }
 
 func buildRequestor(dataFeedId string, credentials *credentials) *requestor {
 	return &requestor{
+		client:      http.DefaultClient,
 		dataFeedID:  dataFeedId,
 		credentials: credentials,
 		token:       &Token{},
@@ -239,32 +595,39 @@ func buildRequestor(dataFeedId string, credentials *credentials) *requestor {
 	}
 }
 
-func (requestor *requestor) doRequest() {
-	requestor.response, requestor.err = (&http.Client{}).Do(requestor.request)
+func (requestor *requestor) doRequest() error {
+	requestor.response, requestor.err = requestor.client.Do(requestor.request)
 	if requestor.err != nil {
-		panic(requestor.err)
+		// a cancelled or expired context is the caller's doing, not a transport failure
+		if err := requestor.request.Context().Err(); err != nil {
+			return err
+		}
+		return &TransportError{URL: requestor.request.URL.String(), Err: requestor.err}
 	}
+	return nil
 }
 
-func (requestor *requestor) saveTokenIfExists(tokenStorage TokenStorage) {
-	if token := requestor.response.Header.Get(HeaderTokenKey); token != "" {
-		requestor.token = NewToken(token)
-		if tokenStorage != nil {
-			tokenStorage.Save(*requestor.token)
-		}
+// discardResponse drains and closes the body of the previous attempt so the connection can be reused
+func (requestor *requestor) discardResponse() {
+	if requestor.response == nil {
+		return
 	}
+	io.Copy(io.Discard, requestor.response.Body)
+	requestor.response.Body.Close()
+	requestor.response = nil
 }
-func (requestor *requestor) handleErrors() {
-	switch requestor.response.StatusCode {
-	case http.StatusUnauthorized:
-		requestor.token.Invalidate()
-	default:
+
+// responseToken returns the token handed out with the response, if any
+func (requestor *requestor) responseToken() *Token {
+	if token := requestor.response.Header.Get(HeaderTokenKey); token != "" {
+		return NewToken(token)
 	}
+	return nil
 }
 
 func (requestor *requestor) setIfModifiedSince(since *time.Time) {
 	if since != nil {
-		requestor.header.Add(HeaderIfModifiedSinceKey, since.Format(time.RFC1123))
+		requestor.header.Add(HeaderIfModifiedSinceKey, since.UTC().Format(http.TimeFormat))
 	}
 }
 
@@ -276,12 +639,17 @@ func (requestor *requestor) setBasicAuth() {
 	requestor.request.SetBasicAuth(requestor.credentials.userName, requestor.credentials.password)
 }
 
+// setAuthenticationToken sends the token the way the API expects it: base64 encoded, like basic auth credentials
 func (requestor *requestor) setAuthenticationToken() {
-	requestor.request.Header.Add(HeaderAuthorizationKey, fmt.Sprintf(HeaderTokenAuthFormatString, requestor.token.tokenString))
+	encodedToken := base64.StdEncoding.EncodeToString([]byte(requestor.token.tokenString))
+	requestor.request.Header.Add(HeaderAuthorizationKey, fmt.Sprintf(HeaderTokenAuthFormatString, encodedToken))
 }
 
-func (requestor *requestor) buildRequest() (err error) {
-	requestor.request, err = http.NewRequest(http.MethodGet, requestor.urlBuilder.Build(), requestor.body)
+func (requestor *requestor) buildRequest(ctx context.Context) (err error) {
+	requestor.request, err = http.NewRequestWithContext(ctx, http.MethodGet, requestor.urlBuilder.Build(), requestor.body)
+	if err != nil {
+		return err
+	}
 	for key, val := range requestor.header {
 		requestor.request.Header[key] = val
 	}
@@ -289,6 +657,14 @@ func (requestor *requestor) buildRequest() (err error) {
 	return err
 }
 
+// authMethod names the authentication the request is sent with, without revealing credentials
+func (requestor *requestor) authMethod() string {
+	if requestor.token.IsValid() {
+		return "token"
+	}
+	return "basic"
+}
+
 func (requestor *requestor) setAuthenticationMethod() {
 	if requestor.token.IsValid() {
 		requestor.setAuthenticationToken()
@@ -299,10 +675,75 @@ func (requestor *requestor) setAuthenticationMethod() {
 
 func (requestor *requestor) unmarshal(out interface{}) error {
 	defer requestor.response.Body.Close()
+	if stream, ok := out.(streamDecoder); ok {
+		return requestor.unmarshalStream(stream)
+	}
 	bodyBuffer := new(bytes.Buffer)
 	if _, err := bodyBuffer.ReadFrom(requestor.response.Body); err != nil {
 		return err
 	}
+	requestor.cacheResponse(bodyBuffer.Bytes())
 	err := xml.Unmarshal(bodyBuffer.Bytes(), out)
 	return err
 }
+
+// unmarshalStream decodes the body as it is read. With a response cache the
+// body is still kept, and cached once it has been decoded completely.
+func (requestor *requestor) unmarshalStream(stream streamDecoder) error {
+	if requestor.cache == nil {
+		return stream.decodeStream(xml.NewDecoder(requestor.response.Body))
+	}
+	bodyBuffer := new(bytes.Buffer)
+	body := io.TeeReader(requestor.response.Body, bodyBuffer)
+	if err := stream.decodeStream(xml.NewDecoder(body)); err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return err
+	}
+	requestor.cacheResponse(bodyBuffer.Bytes())
+	return nil
+}
+
+// cachedResponse looks up the response cache for the requested URL
+func (requestor *requestor) cachedResponse() (*CachedResponse, bool) {
+	if requestor.cache == nil {
+		return nil, false
+	}
+	return requestor.cache.Get(requestor.urlBuilder.Build())
+}
+
+// revalidate makes the request conditional on the cached response having changed
+func (requestor *requestor) revalidate(cachedResponse *CachedResponse) {
+	requestor.revalidating = cachedResponse
+	if cachedResponse.ETag != "" {
+		requestor.setHeaderAttribute(HeaderIfNoneMatchKey, cachedResponse.ETag)
+	}
+	lastModified := cachedResponse.LastModifiedTime()
+	requestor.setIfModifiedSince(&lastModified)
+}
+
+// revalidated returns the cached response being revalidated after the API
+// confirmed it is still current, and marks it as fresh in the cache
+func (requestor *requestor) revalidated() *CachedResponse {
+	if requestor.revalidating == nil {
+		return nil
+	}
+	cachedResponse := *requestor.revalidating
+	cachedResponse.StoredAt = time.Now()
+	requestor.cache.Set(requestor.urlBuilder.Build(), &cachedResponse)
+	return &cachedResponse
+}
+
+// cacheResponse stores the body of a successful response in the response cache
+func (requestor *requestor) cacheResponse(body []byte) {
+	if requestor.cache == nil {
+		return
+	}
+	requestor.cache.Set(requestor.urlBuilder.Build(), &CachedResponse{
+		Body:         body,
+		LastModified: requestor.response.Header.Get(HeaderLastModifiedKey),
+		ETag:         requestor.response.Header.Get(HeaderETagKey),
+		StoredAt:     time.Now(),
+	})
+}
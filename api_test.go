@@ -0,0 +1,187 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetChangedPropertyContextDeadline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	api := NewApi("ABCDEFG", "user", "password")
+	summary := &ChangedPropertySummary{Url: server.URL + "/export/ABCDEFG/v10/branch/1234/property/1", LastAction: Updated}
+	_, err := api.GetChangedPropertyContext(ctx, summary)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected [%v] but found [%v]", context.DeadlineExceeded, err)
+	}
+}
+
+func TestGetChangedPropertyContextCancelledBeforeRequest(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	api := NewApi("ABCDEFG", "user", "password")
+	summary := &ChangedPropertySummary{Url: server.URL + "/export/ABCDEFG/v10/branch/1234/property/1", LastAction: Updated}
+	if _, err := api.GetChangedPropertyContext(ctx, summary); err != context.Canceled {
+		t.Errorf("Expected [%v] but found [%v]", context.Canceled, err)
+	}
+	if requests != 0 {
+		t.Errorf("Expected no requests to reach the server but found [%d]", requests)
+	}
+}
+
+func TestGetChangedPropertyAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderRequestIDKey, "abc-123")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("no such property"))
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password")
+	url := server.URL + "/export/ABCDEFG/v10/branch/1234/property/1"
+	_, err := api.GetChangedProperty(&ChangedPropertySummary{Url: url, LastAction: Updated})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected [%v] but found [%v]", ErrNotFound, err)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Did not expect [%v] to match [%v]", err, ErrUnauthorized)
+	}
+	var apiError *APIError
+	if !errors.As(err, &apiError) {
+		t.Fatalf("Expected an *APIError but found [%T]", err)
+	}
+	if apiError.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected [%d] but found [%d]", http.StatusNotFound, apiError.StatusCode)
+	}
+	if apiError.URL != url {
+		t.Errorf("Expected [%s] but found [%s]", url, apiError.URL)
+	}
+	if apiError.Body != "no such property" {
+		t.Errorf("Expected [%s] but found [%s]", "no such property", apiError.Body)
+	}
+	if apiError.RequestID != "abc-123" {
+		t.Errorf("Expected [%s] but found [%s]", "abc-123", apiError.RequestID)
+	}
+}
+
+func TestGetChangedPropertyTransportError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL + "/export/ABCDEFG/v10/branch/1234/property/1"
+	server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithRetryPolicy(NoRetryPolicy()))
+	_, err := api.GetChangedProperty(&ChangedPropertySummary{Url: url, LastAction: Updated})
+	var transportError *TransportError
+	if !errors.As(err, &transportError) {
+		t.Fatalf("Expected a *TransportError but found [%T]: %v", err, err)
+	}
+	if transportError.URL != url {
+		t.Errorf("Expected [%s] but found [%s]", url, transportError.URL)
+	}
+}
+
+func TestGetChangedPropertyDeleted(t *testing.T) {
+	api := NewApi("ABCDEFG", "user", "password")
+	_, err := api.GetChangedProperty(&ChangedPropertySummary{PropertyID: 42, LastAction: Deleted})
+	if !errors.Is(err, ErrPropertyDeleted) {
+		t.Errorf("Expected [%v] but found [%v]", ErrPropertyDeleted, err)
+	}
+}
+
+func TestApiOptions(t *testing.T) {
+	var path, userAgent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		userAgent = r.Header.Get(HeaderUserAgentKey)
+		w.Write([]byte(`<branches><branch><name>Branch</name><url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/1234</url></branch></branches>`))
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password",
+		WithBaseURL(server.URL+"/"),
+		WithHTTPClient(server.Client()),
+		WithUserAgent("vebra-api-test"),
+	)
+	branches, err := api.GetBranches()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != "/export/ABCDEFG/v10/branch" {
+		t.Errorf("Expected [%s] but found [%s]", "/export/ABCDEFG/v10/branch", path)
+	}
+	if userAgent != "vebra-api-test" {
+		t.Errorf("Expected [%s] but found [%s]", "vebra-api-test", userAgent)
+	}
+	if len(branches.Branches) != 1 || branches.Branches[0].GetClientIDString() != "1234" {
+		t.Errorf("Unexpected branches [%+v]", branches.Branches)
+	}
+
+	// URLs handed out by the feed point at the default BaseURL and must follow WithBaseURL
+	if _, err := api.GetBranch(&branches.Branches[0]); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/export/ABCDEFG/v10/branch/1234" {
+		t.Errorf("Expected [%s] but found [%s]", "/export/ABCDEFG/v10/branch/1234", path)
+	}
+}
+
+// countingTransport counts the requests it passes on to http.DefaultTransport
+type countingTransport struct {
+	requests int
+}
+
+func (transport *countingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.requests++
+	return http.DefaultTransport.RoundTrip(request)
+}
+
+func TestApiTransportAndClientOptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<branches></branches>`))
+	}))
+	defer server.Close()
+
+	transport := new(countingTransport)
+	client := &http.Client{}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithTransport(transport), WithHTTPClient(client))
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if transport.requests != 1 || client.Transport != nil {
+		t.Errorf("Expected [1] request through the transport, leaving the client alone, but found [%d]", transport.requests)
+	}
+
+	api = NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithHTTPClient(nil))
+	if _, err := api.GetBranches(); err != nil {
+		t.Errorf("Expected a nil client to keep the default one but found [%v]", err)
+	}
+}
+
+func TestApiWithTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithTimeout(50*time.Millisecond))
+	if _, err := api.GetBranches(); err != context.DeadlineExceeded {
+		t.Errorf("Expected [%v] but found [%v]", context.DeadlineExceeded, err)
+	}
+}
@@ -0,0 +1,197 @@
+package api
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderLastModifiedKey = "Last-Modified"
+	HeaderETagKey         = "ETag"
+	HeaderIfNoneMatchKey  = "If-None-Match"
+)
+
+// ResponseCache stores raw response bodies keyed by request URL. Cached
+// responses are revalidated with conditional requests and served locally when
+// the API reports them as not modified. Implementations must be safe for
+// concurrent use.
+type ResponseCache interface {
+	Get(url string) (*CachedResponse, bool)
+	Set(url string, response *CachedResponse)
+}
+
+// CachedResponse is a response body stored in a ResponseCache
+// Contains:
+// Body: The raw response body
+// LastModified: The Last-Modified header of the response, if any
+// ETag: The ETag header of the response, if any
+// StoredAt: When the response was last received or revalidated
+type CachedResponse struct {
+	Body         []byte    `json:"-"`
+	LastModified string    `json:"lastModified"`
+	ETag         string    `json:"etag"`
+	StoredAt     time.Time `json:"storedAt"`
+}
+
+// LastModifiedTime returns the time the cached response claims to be modified at,
+// falling back to when it was stored
+func (response *CachedResponse) LastModifiedTime() time.Time {
+	if lastModified, err := http.ParseTime(response.LastModified); err == nil {
+		return lastModified
+	}
+	return response.StoredAt
+}
+
+// MemoryResponseCache is an in-memory ResponseCache evicting the least recently used
+// response once it holds more than its capacity
+type MemoryResponseCache struct {
+	mutex     sync.Mutex
+	capacity  int
+	responses map[string]*list.Element
+	recency   *list.List
+}
+
+type memoryCacheEntry struct {
+	url      string
+	response *CachedResponse
+}
+
+// NewMemoryResponseCache returns an unbounded MemoryResponseCache
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return NewLRUResponseCache(0)
+}
+
+// NewLRUResponseCache returns a MemoryResponseCache holding at most capacity responses.
+// A capacity below 1 means unbounded.
+func NewLRUResponseCache(capacity int) *MemoryResponseCache {
+	return &MemoryResponseCache{
+		capacity:  capacity,
+		responses: make(map[string]*list.Element),
+		recency:   list.New(),
+	}
+}
+
+func (cache *MemoryResponseCache) Get(url string) (*CachedResponse, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, ok := cache.responses[url]
+	if !ok {
+		return nil, false
+	}
+	cache.recency.MoveToFront(element)
+	return element.Value.(*memoryCacheEntry).response, true
+}
+
+func (cache *MemoryResponseCache) Set(url string, response *CachedResponse) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if element, ok := cache.responses[url]; ok {
+		element.Value.(*memoryCacheEntry).response = response
+		cache.recency.MoveToFront(element)
+		return
+	}
+	cache.responses[url] = cache.recency.PushFront(&memoryCacheEntry{url, response})
+	if cache.capacity > 0 && cache.recency.Len() > cache.capacity {
+		oldest := cache.recency.Back()
+		cache.recency.Remove(oldest)
+		delete(cache.responses, oldest.Value.(*memoryCacheEntry).url)
+	}
+}
+
+// Len returns the number of cached responses
+func (cache *MemoryResponseCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.recency.Len()
+}
+
+// DiskResponseCache is a ResponseCache keeping every response in a directory,
+// so cached responses survive restarts. Each URL is stored as a body file and
+// a JSON metadata file named after the SHA-256 of the URL.
+type DiskResponseCache struct {
+	mutex     sync.Mutex
+	directory string
+}
+
+type diskCacheMetadata struct {
+	URL string `json:"url"`
+	CachedResponse
+}
+
+// NewDiskResponseCache returns a DiskResponseCache storing responses in directory, creating it if needed
+func NewDiskResponseCache(directory string) (*DiskResponseCache, error) {
+	if err := os.MkdirAll(directory, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskResponseCache{directory: directory}, nil
+}
+
+func (cache *DiskResponseCache) Get(url string) (*CachedResponse, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	metadataFile, bodyFile := cache.fileNames(url)
+	content, err := ioutil.ReadFile(metadataFile)
+	if err != nil {
+		return nil, false
+	}
+	metadata := new(diskCacheMetadata)
+	if err := json.Unmarshal(content, metadata); err != nil || metadata.URL != url {
+		return nil, false
+	}
+	if metadata.Body, err = ioutil.ReadFile(bodyFile); err != nil {
+		return nil, false
+	}
+	return &metadata.CachedResponse, true
+}
+
+// Set stores the response. Failures to write are ignored; the response is
+// simply fetched again next time.
+func (cache *DiskResponseCache) Set(url string, response *CachedResponse) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	metadataFile, bodyFile := cache.fileNames(url)
+	metadata, err := json.Marshal(&diskCacheMetadata{URL: url, CachedResponse: *response})
+	if err != nil {
+		return
+	}
+	if err := writeFileAtomic(bodyFile, response.Body, 0600); err != nil {
+		return
+	}
+	writeFileAtomic(metadataFile, metadata, 0600)
+}
+
+func (cache *DiskResponseCache) fileNames(url string) (metadataFile string, bodyFile string) {
+	hash := sha256.Sum256([]byte(url))
+	name := filepath.Join(cache.directory, hex.EncodeToString(hash[:]))
+	return name + ".json", name + ".body"
+}
+
+// writeFileAtomic writes data to a temporary file next to fileName and renames
+// it into place, so readers never see a partially written file
+func writeFileAtomic(fileName string, data []byte, perm os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), fileName)
+}
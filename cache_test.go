@@ -0,0 +1,204 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var testLastModified = time.Date(2017, 3, 21, 13, 39, 33, 0, time.UTC)
+
+// newConditionalServer serves a property that was last modified at testLastModified
+func newConditionalServer(requests *[]*http.Request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests = append(*requests, r)
+		if since, err := http.ParseTime(r.Header.Get(HeaderIfModifiedSinceKey)); err == nil && !testLastModified.After(since) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set(HeaderLastModifiedKey, testLastModified.Format(http.TimeFormat))
+		w.Write([]byte(`<property id="26858499"><address><town>Leeds</town></address></property>`))
+	}))
+}
+
+var testBranchSummary = &BranchSummary{Url: "http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741"}
+
+func TestGetPropertyIfModifiedSince(t *testing.T) {
+	var requests []*http.Request
+	server := newConditionalServer(&requests)
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	summary := PropertySummary{PropertyID: 26858499}
+
+	property, notModified, err := api.GetPropertyIfModifiedSince(testBranchSummary, summary, testLastModified.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if notModified || property == nil || property.ID != 26858499 {
+		t.Errorf("Expected property [26858499] to be fetched but found [%v] notModified [%t]", property, notModified)
+	}
+	if header := requests[0].Header.Get(HeaderIfModifiedSinceKey); header != "Tue, 21 Mar 2017 12:39:33 GMT" {
+		t.Errorf("Expected [%s] but found [%s]", "Tue, 21 Mar 2017 12:39:33 GMT", header)
+	}
+
+	property, notModified, err = api.GetPropertyIfModifiedSince(testBranchSummary, summary, testLastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notModified || property != nil {
+		t.Errorf("Expected no property and notModified without a cache but found [%v] notModified [%t]", property, notModified)
+	}
+}
+
+func TestGetPropertyIfModifiedSinceServedFromCache(t *testing.T) {
+	var requests []*http.Request
+	server := newConditionalServer(&requests)
+	defer server.Close()
+
+	cache := NewMemoryResponseCache()
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithResponseCache(cache, 0))
+	summary := PropertySummary{PropertyID: 26858499}
+
+	if _, err := api.GetProperty(testBranchSummary, summary); err != nil {
+		t.Fatal(err)
+	}
+	cached, ok := cache.Get(server.URL + "/export/ABCDEFG/v10/branch/3741/property/26858499")
+	if !ok {
+		t.Fatal("Expected the response to be cached")
+	}
+	if cached.LastModified != testLastModified.Format(http.TimeFormat) {
+		t.Errorf("Expected [%s] but found [%s]", testLastModified.Format(http.TimeFormat), cached.LastModified)
+	}
+
+	property, notModified, err := api.GetPropertyIfModifiedSince(testBranchSummary, summary, testLastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !notModified {
+		t.Error("Expected notModified")
+	}
+	if property == nil || property.Address.Town != "Leeds" {
+		t.Errorf("Expected the property to be served from cache but found [%v]", property)
+	}
+}
+
+func TestGetPropertyUnexpectedNotModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	_, err := api.GetProperty(testBranchSummary, PropertySummary{PropertyID: 26858499})
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected a [304] *APIError but found [%v]", err)
+	}
+}
+
+func TestLRUResponseCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewLRUResponseCache(2)
+	cache.Set("a", &CachedResponse{Body: []byte("a")})
+	cache.Set("b", &CachedResponse{Body: []byte("b")})
+	cache.Get("a")
+	cache.Set("c", &CachedResponse{Body: []byte("c")})
+
+	if _, ok := cache.Get("b"); ok {
+		t.Error("Expected [b] to be evicted")
+	}
+	for _, url := range []string{"a", "c"} {
+		if response, ok := cache.Get(url); !ok || string(response.Body) != url {
+			t.Errorf("Expected [%s] to be cached but found [%v]", url, response)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected [2] cached responses but found [%d]", cache.Len())
+	}
+}
+
+func TestDiskResponseCache(t *testing.T) {
+	directory := t.TempDir()
+	cache, err := NewDiskResponseCache(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storedAt := time.Now().Round(time.Second)
+	cache.Set("http://webservices.vebra.com/a", &CachedResponse{Body: []byte("<a/>"), ETag: `"1"`, StoredAt: storedAt})
+
+	// a second instance on the same directory sees what the first one stored
+	reopened, err := NewDiskResponseCache(directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, ok := reopened.Get("http://webservices.vebra.com/a")
+	if !ok {
+		t.Fatal("Expected the response to be cached")
+	}
+	if string(response.Body) != "<a/>" || response.ETag != `"1"` || !response.StoredAt.Equal(storedAt) {
+		t.Errorf("Unexpected cached response [%+v]", response)
+	}
+	if _, ok := reopened.Get("http://webservices.vebra.com/b"); ok {
+		t.Error("Did not expect an uncached URL to be found")
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	var requests []*http.Request
+	server := newConditionalServer(&requests)
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithResponseCache(NewMemoryResponseCache(), time.Hour))
+	summary := PropertySummary{PropertyID: 26858499}
+	for i := 0; i < 3; i++ {
+		property, err := api.GetProperty(testBranchSummary, summary)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if property.Address.Town != "Leeds" {
+			t.Errorf("Expected [Leeds] but found [%s]", property.Address.Town)
+		}
+	}
+	if len(requests) != 1 {
+		t.Errorf("Expected fresh responses to be served without a request but found [%d] requests", len(requests))
+	}
+}
+
+func TestResponseCacheRevalidation(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		if r.Header.Get(HeaderIfNoneMatchKey) == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set(HeaderETagKey, `"v1"`)
+		w.Write([]byte(`<property id="26858499"><address><town>Leeds</town></address></property>`))
+	}))
+	defer server.Close()
+
+	cache := NewMemoryResponseCache()
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithResponseCache(cache, 0))
+	summary := PropertySummary{PropertyID: 26858499}
+	if _, err := api.GetProperty(testBranchSummary, summary); err != nil {
+		t.Fatal(err)
+	}
+	url := server.URL + "/export/ABCDEFG/v10/branch/3741/property/26858499"
+	first, _ := cache.Get(url)
+
+	property, err := api.GetProperty(testBranchSummary, summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if property.Address.Town != "Leeds" {
+		t.Errorf("Expected the revalidated property to be decoded from cache but found [%+v]", property.Address)
+	}
+	if len(requests) != 2 || requests[1].Header.Get(HeaderIfNoneMatchKey) != `"v1"` {
+		t.Errorf("Expected a conditional request with If-None-Match")
+	}
+	if second, _ := cache.Get(url); !second.StoredAt.After(first.StoredAt) {
+		t.Errorf("Expected the revalidated response to be marked fresh")
+	}
+}
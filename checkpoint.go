@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// CheckpointKind tells which changes a checkpoint tracks
+type CheckpointKind string
+
+const (
+	// CheckpointProperties tracks GetChangedProperties
+	CheckpointProperties CheckpointKind = "properties"
+	// CheckpointFiles tracks GetChangedFiles
+	CheckpointFiles CheckpointKind = "files"
+)
+
+// CheckpointStore persists, per data feed and kind of change, the time up to
+// which every change has been applied
+type CheckpointStore interface {
+	// LoadCheckpoint returns the zero time when no checkpoint was committed yet
+	LoadCheckpoint(dataFeedID string, kind CheckpointKind) (time.Time, error)
+	// CommitCheckpoint moves the checkpoint forward to mark. A mark before the
+	// stored one is ignored, so a slow worker cannot undo a faster one's progress.
+	CommitCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error
+	// RewindCheckpoint sets the checkpoint to mark even if it is earlier, so the
+	// changes since mark are applied again. The zero time removes the checkpoint.
+	RewindCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error
+}
+
+// CheckpointHighWaterMark returns the HighWaterMarkStorage of one feed and kind
+// of change kept in store, e.g. for NewSyncer
+func CheckpointHighWaterMark(store CheckpointStore, dataFeedID string, kind CheckpointKind) HighWaterMarkStorage {
+	return &checkpointHighWaterMark{store, dataFeedID, kind}
+}
+
+type checkpointHighWaterMark struct {
+	store      CheckpointStore
+	dataFeedID string
+	kind       CheckpointKind
+}
+
+func (mark *checkpointHighWaterMark) LoadHighWaterMark() (time.Time, error) {
+	return mark.store.LoadCheckpoint(mark.dataFeedID, mark.kind)
+}
+
+func (mark *checkpointHighWaterMark) SaveHighWaterMark(t time.Time) error {
+	return mark.store.CommitCheckpoint(mark.dataFeedID, mark.kind, t)
+}
+
+// fileCheckpointVersion is the version of the checkpoint file format written by FileCheckpointStore
+const fileCheckpointVersion = 1
+
+// fileCheckpoints is the JSON document stored by FileCheckpointStore
+type fileCheckpoints struct {
+	Version int                                     `json:"version"`
+	Feeds   map[string]map[CheckpointKind]time.Time `json:"feeds"`
+}
+
+// FileCheckpointStore implements CheckpointStore with the checkpoints of every
+// feed in one JSON file. Processes sharing the file are serialised with an
+// advisory lock.
+type FileCheckpointStore struct {
+	fileName string
+}
+
+// NewFileCheckpointStore returns a CheckpointStore writing to fileName
+func NewFileCheckpointStore(fileName string) *FileCheckpointStore {
+	return &FileCheckpointStore{fileName: fileName}
+}
+
+// LoadCheckpoint reads the checkpoint of a feed and kind of change
+func (store *FileCheckpointStore) LoadCheckpoint(dataFeedID string, kind CheckpointKind) (time.Time, error) {
+	unlock, err := store.lock()
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer unlock()
+	checkpoints, err := store.load()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return checkpoints.Feeds[dataFeedID][kind], nil
+}
+
+// CommitCheckpoint moves the checkpoint of a feed and kind of change forward to mark
+func (store *FileCheckpointStore) CommitCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error {
+	return store.update(dataFeedID, kind, mark, false)
+}
+
+// RewindCheckpoint sets the checkpoint of a feed and kind of change to mark
+func (store *FileCheckpointStore) RewindCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error {
+	return store.update(dataFeedID, kind, mark, true)
+}
+
+func (store *FileCheckpointStore) update(dataFeedID string, kind CheckpointKind, mark time.Time, rewind bool) error {
+	unlock, err := store.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	checkpoints, err := store.load()
+	if err != nil {
+		return err
+	}
+	feed := checkpoints.Feeds[dataFeedID]
+	if !rewind && !mark.After(feed[kind]) {
+		return nil
+	}
+	if feed == nil {
+		feed = make(map[CheckpointKind]time.Time)
+		checkpoints.Feeds[dataFeedID] = feed
+	}
+	if mark.IsZero() {
+		delete(feed, kind)
+	} else {
+		feed[kind] = mark.UTC()
+	}
+	data, err := json.MarshalIndent(checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(store.fileName, data, 0600)
+}
+
+// load reads the checkpoint file, which may not exist yet
+func (store *FileCheckpointStore) load() (*fileCheckpoints, error) {
+	checkpoints := &fileCheckpoints{Version: fileCheckpointVersion, Feeds: make(map[string]map[CheckpointKind]time.Time)}
+	data, err := ioutil.ReadFile(store.fileName)
+	if os.IsNotExist(err) {
+		return checkpoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(data, checkpoints); err != nil {
+		return nil, fmt.Errorf("checkpoint file [%s] is corrupt: %w", store.fileName, err)
+	}
+	if checkpoints.Version != fileCheckpointVersion {
+		return nil, fmt.Errorf("checkpoint file [%s] has unsupported version [%d]", store.fileName, checkpoints.Version)
+	}
+	if checkpoints.Feeds == nil {
+		checkpoints.Feeds = make(map[string]map[CheckpointKind]time.Time)
+	}
+	return checkpoints, nil
+}
+
+// lock serialises the processes sharing the checkpoint file
+func (store *FileCheckpointStore) lock() (unlock func(), err error) {
+	return lockBeside(store.fileName)
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCheckpointStores(t *testing.T) map[string]CheckpointStore {
+	db := newTestTokenDB(t)
+	if err := CreateCheckpointTable(db, DefaultCheckpointTable); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]CheckpointStore{
+		"file": NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json")),
+		"sql":  NewSQLCheckpointStore(db),
+	}
+}
+
+func TestCheckpointStores(t *testing.T) {
+	first := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.Add(time.Hour)
+	for name, store := range newTestCheckpointStores(t) {
+		if mark, err := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); err != nil || !mark.IsZero() {
+			t.Errorf("[%s] Expected no checkpoint but found [%s] [%v]", name, mark, err)
+		}
+		for _, commit := range []struct {
+			dataFeedID string
+			kind       CheckpointKind
+			mark       time.Time
+		}{
+			{"ABCDEFG", CheckpointProperties, second},
+			{"ABCDEFG", CheckpointProperties, first},
+			{"ABCDEFG", CheckpointFiles, first},
+			{"HIJKLMN", CheckpointProperties, first},
+		} {
+			if err := store.CommitCheckpoint(commit.dataFeedID, commit.kind, commit.mark); err != nil {
+				t.Fatalf("[%s] %s", name, err)
+			}
+		}
+
+		for _, expected := range []struct {
+			dataFeedID string
+			kind       CheckpointKind
+			mark       time.Time
+		}{
+			{"ABCDEFG", CheckpointProperties, second},
+			{"ABCDEFG", CheckpointFiles, first},
+			{"HIJKLMN", CheckpointProperties, first},
+			{"HIJKLMN", CheckpointFiles, time.Time{}},
+		} {
+			found, err := store.LoadCheckpoint(expected.dataFeedID, expected.kind)
+			if err != nil {
+				t.Fatalf("[%s] %s", name, err)
+			}
+			if !found.Equal(expected.mark) {
+				t.Errorf("[%s] Expected checkpoint [%s] for [%s] [%s] but found [%s]", name, expected.mark, expected.dataFeedID, expected.kind, found)
+			}
+		}
+
+		if err := store.RewindCheckpoint("ABCDEFG", CheckpointProperties, first); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); !found.Equal(first) {
+			t.Errorf("[%s] Expected the checkpoint to be rewound to [%s] but found [%s]", name, first, found)
+		}
+		if err := store.RewindCheckpoint("ABCDEFG", CheckpointProperties, time.Time{}); err != nil {
+			t.Fatal(err)
+		}
+		if found, _ := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); !found.IsZero() {
+			t.Errorf("[%s] Expected the checkpoint to be removed but found [%s]", name, found)
+		}
+	}
+}
+
+func TestSQLCheckpointStoreSurvivesConcurrentFirstCommit(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "checkpoints.db")
+	other, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := CreateCheckpointTable(other, DefaultCheckpointTable); err != nil {
+		t.Fatal(err)
+	}
+
+	// another worker commits a later checkpoint just before this one inserts
+	later := time.Date(2019, 1, 2, 4, 4, 5, 0, time.UTC)
+	db := sql.OpenDB(&racingConnector{fileName: fileName, beforeInsert: func() {
+		if err := NewSQLCheckpointStore(other).CommitCheckpoint("ABCDEFG", CheckpointProperties, later); err != nil {
+			t.Error(err)
+		}
+	}})
+	defer db.Close()
+
+	store := NewSQLCheckpointStore(db)
+	if err := store.CommitCheckpoint("ABCDEFG", CheckpointProperties, later.Add(-time.Hour)); err != nil {
+		t.Fatalf("Expected the duplicate insert to be retried as an update but found [%v]", err)
+	}
+	if found, err := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); err != nil || !found.Equal(later) {
+		t.Errorf("Expected the later checkpoint [%s] to be kept but found [%s] [%v]", later, found, err)
+	}
+}
+
+// failingBatchSink is a memorySink whose commits fail
+type failingBatchSink struct {
+	*memorySink
+	commits int
+}
+
+func (sink *failingBatchSink) Commit() error {
+	sink.commits++
+	return errors.New("commit failed")
+}
+
+func TestSyncerCommitsCheckpointAfterSinkCommit(t *testing.T) {
+	server := newSyncStub(changedPropertiesBody("1:updated"))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints.json"))
+	sink := &failingBatchSink{memorySink: newMemorySink()}
+	syncer := NewSyncer(api, sink, CheckpointHighWaterMark(store, "ABCDEFG", CheckpointProperties)).
+		SetStartTime(time.Now().Add(-time.Hour))
+
+	if _, err := syncer.Sync(context.Background()); err == nil || sink.commits != 1 {
+		t.Errorf("Expected the failed commit to fail the sync but found [%v] after [%d] commits", err, sink.commits)
+	}
+	if mark, err := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); err != nil || !mark.IsZero() {
+		t.Errorf("Expected no checkpoint but found [%s] [%v]", mark, err)
+	}
+
+	syncer = NewSyncer(api, sink.memorySink, CheckpointHighWaterMark(store, "ABCDEFG", CheckpointProperties)).
+		SetStartTime(time.Now().Add(-time.Hour))
+	result, err := syncer.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mark, _ := store.LoadCheckpoint("ABCDEFG", CheckpointProperties); !mark.Equal(result.Until) {
+		t.Errorf("Expected checkpoint [%s] but found [%s]", result.Until, mark)
+	}
+}
@@ -0,0 +1,259 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// DefaultPoolConcurrency is the number of feeds ClientPool.Each works on at once unless told otherwise
+const DefaultPoolConcurrency = 4
+
+// ErrUnknownFeed is returned when asking a ClientPool for a data feed it was not configured with
+var ErrUnknownFeed = errors.New("unknown data feed")
+
+// FeedConfig describes one estate agent's data feed served by a ClientPool
+// Contains:
+// DataFeedID: The data feed ID issued by Vebra
+// Username: The username of the feed's credentials
+// Password: The password of the feed's credentials
+// TokenStorage: Where the feed's token is kept. Falls back to the pool's token storage factory, if any.
+// RateLimit: The feed's rate limit. Falls back to the pool's options.
+// Options: Further options applied after the pool's options
+type FeedConfig struct {
+	DataFeedID   string
+	Username     string
+	Password     string
+	TokenStorage TokenStorage
+	RateLimit    *RateLimit
+	Options      []Option
+}
+
+// ClientPool manages the Api clients of many data feeds. Each feed's Api is
+// created when first used, with its own token and rate limits. A ClientPool is
+// safe for concurrent use.
+type ClientPool struct {
+	mutex        sync.Mutex
+	feeds        map[string]FeedConfig
+	order        []string
+	clients      map[string]*Api
+	options      []Option
+	concurrency  int
+	tokenStorage func(feed FeedConfig) (TokenStorage, error)
+}
+
+// NewClientPool returns an empty ClientPool applying options to every Api it creates
+func NewClientPool(options ...Option) *ClientPool {
+	return &ClientPool{
+		feeds:       make(map[string]FeedConfig),
+		clients:     make(map[string]*Api),
+		options:     options,
+		concurrency: DefaultPoolConcurrency,
+	}
+}
+
+// SetConcurrency sets the number of feeds Each works on at once
+func (pool *ClientPool) SetConcurrency(concurrency int) *ClientPool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	pool.concurrency = concurrency
+	return pool
+}
+
+// SetTokenStorageFactory sets how the token storage of feeds configured without one is created,
+// e.g. a FileTokenStorage per feed or a SQLTokenStorage sharing one table
+func (pool *ClientPool) SetTokenStorageFactory(factory func(feed FeedConfig) (TokenStorage, error)) *ClientPool {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.tokenStorage = factory
+	return pool
+}
+
+// Add registers feeds with the pool. Adding a data feed ID twice is an error.
+func (pool *ClientPool) Add(feeds ...FeedConfig) error {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for _, feed := range feeds {
+		if feed.DataFeedID == "" {
+			return errors.New("feed without data feed ID")
+		}
+		if _, ok := pool.feeds[feed.DataFeedID]; ok {
+			return fmt.Errorf("data feed [%s] added twice", feed.DataFeedID)
+		}
+		pool.feeds[feed.DataFeedID] = feed
+		pool.order = append(pool.order, feed.DataFeedID)
+	}
+	return nil
+}
+
+// Remove forgets a feed and its Api
+func (pool *ClientPool) Remove(dataFeedID string) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if _, ok := pool.feeds[dataFeedID]; !ok {
+		return
+	}
+	delete(pool.feeds, dataFeedID)
+	delete(pool.clients, dataFeedID)
+	for i, id := range pool.order {
+		if id == dataFeedID {
+			pool.order = append(pool.order[:i:i], pool.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// DataFeedIDs returns the IDs of the pool's feeds in the order they were added
+func (pool *ClientPool) DataFeedIDs() []string {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return append([]string(nil), pool.order...)
+}
+
+// Get returns the Api of a feed, creating it on first use. The Api is created
+// without holding the pool's lock, as the token storage factory may be slow, so
+// concurrent first calls for a feed may each create one and all get the first stored.
+func (pool *ClientPool) Get(dataFeedID string) (*Api, error) {
+	pool.mutex.Lock()
+	if api, ok := pool.clients[dataFeedID]; ok {
+		pool.mutex.Unlock()
+		return api, nil
+	}
+	feed, ok := pool.feeds[dataFeedID]
+	options := append([]Option(nil), pool.options...)
+	tokenStorage := pool.tokenStorage
+	pool.mutex.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: [%s]", ErrUnknownFeed, dataFeedID)
+	}
+
+	if feed.RateLimit != nil {
+		options = append(options, WithRateLimit(*feed.RateLimit))
+	}
+	options = append(options, feed.Options...)
+	api := NewApi(feed.DataFeedID, feed.Username, feed.Password, options...)
+
+	storage := feed.TokenStorage
+	if storage == nil && tokenStorage != nil {
+		var err error
+		if storage, err = tokenStorage(feed); err != nil {
+			return nil, fmt.Errorf("token storage for data feed [%s]: %w", dataFeedID, err)
+		}
+	}
+	if storage != nil {
+		api.SetTokenStorage(storage)
+	}
+
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if stored, ok := pool.clients[dataFeedID]; ok {
+		return stored, nil
+	}
+	if _, ok := pool.feeds[dataFeedID]; ok {
+		pool.clients[dataFeedID] = api
+	}
+	return api, nil
+}
+
+// Each calls fn with the Api of every feed, working on up to the pool's
+// concurrency feeds at once. Feeds not yet started when ctx is done fail with
+// ctx's error. Failures are collected into a *PoolError instead of stopping the
+// other feeds.
+func (pool *ClientPool) Each(ctx context.Context, fn func(ctx context.Context, dataFeedID string, api *Api) error) error {
+	pool.mutex.Lock()
+	concurrency := pool.concurrency
+	pool.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	poolError := new(PoolError)
+	fail := func(dataFeedID string, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		poolError.Errors = append(poolError.Errors, &FeedError{DataFeedID: dataFeedID, Err: err})
+	}
+
+	semaphore := make(chan struct{}, concurrency)
+	for _, dataFeedID := range pool.DataFeedIDs() {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			fail(dataFeedID, ctx.Err())
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			<-semaphore
+			fail(dataFeedID, err)
+			continue
+		}
+		wg.Add(1)
+		go func(dataFeedID string) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			api, err := pool.Get(dataFeedID)
+			if err == nil {
+				err = fn(ctx, dataFeedID, api)
+			}
+			if err != nil {
+				fail(dataFeedID, err)
+			}
+		}(dataFeedID)
+	}
+	wg.Wait()
+
+	if len(poolError.Errors) == 0 {
+		return nil
+	}
+	return poolError
+}
+
+// FeedError is the failure of one feed in ClientPool.Each
+type FeedError struct {
+	DataFeedID string
+	Err        error
+}
+
+func (e *FeedError) Error() string {
+	return fmt.Sprintf("data feed [%s]: %s", e.DataFeedID, e.Err)
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// PoolError collects the feeds that failed in ClientPool.Each
+type PoolError struct {
+	Errors []*FeedError
+}
+
+func (e *PoolError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("[%d] data feeds failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap lets errors.Is and errors.As look at every feed's error
+func (e *PoolError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// Failed returns the error of a feed, or nil if it succeeded
+func (e *PoolError) Failed(dataFeedID string) error {
+	for _, err := range e.Errors {
+		if err.DataFeedID == dataFeedID {
+			return err.Err
+		}
+	}
+	return nil
+}
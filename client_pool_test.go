@@ -0,0 +1,184 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newFeedServer answers basic auth for every feed but fails requests for the feeds in failing
+func newFeedServer(failing ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, feed := range failing {
+			if strings.Contains(r.URL.Path, "/"+feed+"/") {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		w.Header().Set(HeaderTokenKey, "token")
+		w.Write([]byte(`<branches></branches>`))
+	}))
+}
+
+func TestClientPoolCreatesApiLazily(t *testing.T) {
+	var storages int32
+	pool := NewClientPool(WithBaseURL("http://localhost")).
+		SetTokenStorageFactory(func(feed FeedConfig) (TokenStorage, error) {
+			atomic.AddInt32(&storages, 1)
+			return &memoryTokenStorage{}, nil
+		})
+	if err := pool.Add(FeedConfig{DataFeedID: "FEEDA", Username: "a"}, FeedConfig{DataFeedID: "FEEDB", Username: "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.Add(FeedConfig{DataFeedID: "FEEDA"}); err == nil {
+		t.Errorf("Expected an error adding a feed twice")
+	}
+	if created := atomic.LoadInt32(&storages); created != 0 {
+		t.Errorf("Expected no Api to be created yet but found [%d] token storages", created)
+	}
+
+	first, err := pool.Get("FEEDA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := pool.Get("FEEDA")
+	other, _ := pool.Get("FEEDB")
+	if first != second || first == other {
+		t.Errorf("Expected one Api per feed")
+	}
+	if first.dataFeedId != "FEEDA" || first.credentials.userName != "a" || first.baseURL != "http://localhost" {
+		t.Errorf("Expected the Api to be configured for FEEDA but found [%s] [%s] [%s]",
+			first.dataFeedId, first.credentials.userName, first.baseURL)
+	}
+	if created := atomic.LoadInt32(&storages); created != 2 {
+		t.Errorf("Expected [2] token storages but found [%d]", created)
+	}
+	if _, err := pool.Get("FEEDC"); !errors.Is(err, ErrUnknownFeed) {
+		t.Errorf("Expected [%s] but found [%v]", ErrUnknownFeed, err)
+	}
+}
+
+func TestClientPoolGetDoesNotWaitForOtherFeeds(t *testing.T) {
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	pool := NewClientPool(WithBaseURL("http://localhost")).
+		SetTokenStorageFactory(func(feed FeedConfig) (TokenStorage, error) {
+			if feed.DataFeedID == "FEEDA" {
+				close(blocked)
+				<-release
+			}
+			return &memoryTokenStorage{}, nil
+		})
+	if err := pool.Add(FeedConfig{DataFeedID: "FEEDA"}, FeedConfig{DataFeedID: "FEEDB"}); err != nil {
+		t.Fatal(err)
+	}
+
+	slow := make(chan *Api)
+	go func() {
+		api, _ := pool.Get("FEEDA")
+		slow <- api
+	}()
+	<-blocked
+	done := make(chan error)
+	go func() {
+		_, err := pool.Get("FEEDB")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected FEEDB not to wait for the token storage of FEEDA")
+	}
+
+	close(release)
+	first := <-slow
+	if second, err := pool.Get("FEEDA"); err != nil || first == nil || second != first {
+		t.Errorf("Expected the Api created for FEEDA to be kept but found [%p] and [%p] [%v]", first, second, err)
+	}
+}
+
+func TestClientPoolIsolatesRateLimits(t *testing.T) {
+	pool := NewClientPool(WithRateLimit(RateLimit{RequestsPerSecond: 1}))
+	pool.Add(FeedConfig{DataFeedID: "FEEDA"}, FeedConfig{DataFeedID: "FEEDB", RateLimit: &RateLimit{RequestsPerSecond: 5}})
+
+	a, _ := pool.Get("FEEDA")
+	b, _ := pool.Get("FEEDB")
+	if a.limiter == nil || b.limiter == nil || a.limiter == b.limiter {
+		t.Fatalf("Expected each feed to have its own rate limiter")
+	}
+	if b.limiter.rate != 5 {
+		t.Errorf("Expected the feed's own rate [5] but found [%f]", b.limiter.rate)
+	}
+}
+
+func TestClientPoolEachReportsFeedErrors(t *testing.T) {
+	server := newFeedServer("FEEDB")
+	defer server.Close()
+
+	pool := NewClientPool(WithBaseURL(server.URL), WithRetryPolicy(NoRetryPolicy())).SetConcurrency(2)
+	for _, id := range []string{"FEEDA", "FEEDB", "FEEDC", "FEEDD"} {
+		pool.Add(FeedConfig{DataFeedID: id})
+	}
+
+	var mutex sync.Mutex
+	var running, maxRunning int
+	visited := make(map[string]bool)
+	err := pool.Each(context.Background(), func(ctx context.Context, dataFeedID string, api *Api) error {
+		mutex.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		visited[dataFeedID] = true
+		mutex.Unlock()
+		defer func() {
+			mutex.Lock()
+			running--
+			mutex.Unlock()
+		}()
+		_, err := api.GetBranchesContext(ctx)
+		return err
+	})
+
+	if len(visited) != 4 {
+		t.Errorf("Expected every feed to be visited but found [%v]", visited)
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most [2] feeds at once but found [%d]", maxRunning)
+	}
+	var poolError *PoolError
+	if !errors.As(err, &poolError) || len(poolError.Errors) != 1 {
+		t.Fatalf("Expected a PoolError for one feed but found [%v]", err)
+	}
+	var apiError *APIError
+	if !errors.As(poolError.Failed("FEEDB"), &apiError) || apiError.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected FEEDB to fail with [500] but found [%v]", poolError.Failed("FEEDB"))
+	}
+	if !errors.As(err, &apiError) {
+		t.Errorf("Expected errors.As to find the APIError through the PoolError")
+	}
+}
+
+func TestClientPoolEachStopsWhenContextIsDone(t *testing.T) {
+	pool := NewClientPool().SetConcurrency(1)
+	pool.Add(FeedConfig{DataFeedID: "FEEDA"}, FeedConfig{DataFeedID: "FEEDB"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err := pool.Each(ctx, func(ctx context.Context, dataFeedID string, api *Api) error {
+		cancel()
+		return nil
+	})
+	var poolError *PoolError
+	if !errors.As(err, &poolError) || !errors.Is(poolError.Failed("FEEDB"), context.Canceled) {
+		t.Errorf("Expected FEEDB to be cancelled but found [%v]", err)
+	}
+}
@@ -0,0 +1,396 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigEnvPrefix prefixes the environment variables read by LoadConfig and
+// LoadConfigFromEnv. The variable of a key is the prefix followed by the key in
+// upper case with dots replaced by underscores, e.g. VEBRA_DOWNLOADS_MAX_WIDTH
+// for downloads.max_width.
+const ConfigEnvPrefix = "VEBRA_"
+
+// configVariablePattern matches the ${VAR} references expanded in config files
+var configVariablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Config holds everything needed to talk to one data feed, as read from a
+// YAML, TOML or JSON file and the environment.
+// Contains:
+// DataFeedID: The data feed ID issued by Vebra. Required.
+// Username: The username of the feed's credentials. Required.
+// Password: The password of the feed's credentials. Required.
+// BaseURL: Replaces BaseURL, see WithBaseURL
+// UserAgent: The User-Agent header, see WithUserAgent
+// Timeout: Bounds every Api call, see WithTimeout
+// TokenFile: The file the token is kept in, see FileTokenStorage
+// RateLimit: Client-side rate limits, see WithRateLimit
+// Downloads: Where and how property media is downloaded
+// Sync: How often and how widely feeds are synchronised
+type Config struct {
+	DataFeedID string          `json:"data_feed_id" yaml:"data_feed_id" toml:"data_feed_id"`
+	Username   string          `json:"username" yaml:"username" toml:"username"`
+	Password   string          `json:"password" yaml:"password" toml:"password"`
+	BaseURL    string          `json:"base_url" yaml:"base_url" toml:"base_url"`
+	UserAgent  string          `json:"user_agent" yaml:"user_agent" toml:"user_agent"`
+	Timeout    Duration        `json:"timeout" yaml:"timeout" toml:"timeout"`
+	TokenFile  string          `json:"token_file" yaml:"token_file" toml:"token_file"`
+	RateLimit  RateLimitConfig `json:"rate_limit" yaml:"rate_limit" toml:"rate_limit"`
+	Downloads  DownloadConfig  `json:"downloads" yaml:"downloads" toml:"downloads"`
+	Sync       SyncConfig      `json:"sync" yaml:"sync" toml:"sync"`
+}
+
+// RateLimitConfig configures RateLimit from a Config. Requests over the limit wait.
+type RateLimitConfig struct {
+	RequestsPerSecond      float64 `json:"requests_per_second" yaml:"requests_per_second" toml:"requests_per_second"`
+	Burst                  int     `json:"burst" yaml:"burst" toml:"burst"`
+	TokenRequestsPerSecond float64 `json:"token_requests_per_second" yaml:"token_requests_per_second" toml:"token_requests_per_second"`
+	TokenBurst             int     `json:"token_burst" yaml:"token_burst" toml:"token_burst"`
+}
+
+// DownloadConfig configures the FileDownloader built from a Config. Zero values keep its defaults.
+// Contains:
+// OutputDirectory: The directory media files are written below
+// MaxWidth: The maximum width of thumbnails
+// MaxHeight: The maximum height of thumbnails
+// QueueSize: The number of properties queued for download before Download blocks
+type DownloadConfig struct {
+	OutputDirectory string `json:"output_directory" yaml:"output_directory" toml:"output_directory"`
+	MaxWidth        uint   `json:"max_width" yaml:"max_width" toml:"max_width"`
+	MaxHeight       uint   `json:"max_height" yaml:"max_height" toml:"max_height"`
+	QueueSize       int    `json:"queue_size" yaml:"queue_size" toml:"queue_size"`
+}
+
+// SyncConfig holds the settings of a process keeping a copy of the feed up to date
+// Contains:
+// Interval: The time between two synchronisations
+// Concurrency: The number of properties fetched at once
+type SyncConfig struct {
+	Interval    Duration `json:"interval" yaml:"interval" toml:"interval"`
+	Concurrency int      `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+}
+
+// Duration is a time.Duration written like "30s" or "5m" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ConfigError reports an invalid configuration value
+// Contains:
+// Key: The offending key, e.g. downloads.max_width
+// Err: What is wrong with it
+type ConfigError struct {
+	Key string
+	Err error
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("config key [%s]: %s", e.Key, e.Err)
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Err
+}
+
+// LoadConfig reads a config file, choosing the format by its extension (.yaml,
+// .yml, .toml or .json). ${VAR} references in the file's string values are
+// replaced with the environment variable VAR once the file is decoded, so
+// secrets need no quoting, and keys set in the environment, see ConfigEnvPrefix,
+// override the file. The result is validated.
+func LoadConfig(fileName string) (*Config, error) {
+	content, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	config := new(Config)
+	switch extension := strings.ToLower(filepath.Ext(fileName)); extension {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		err = decoder.Decode(config)
+	case ".toml":
+		var metadata toml.MetaData
+		metadata, err = toml.Decode(string(content), config)
+		if undecoded := metadata.Undecoded(); err == nil && len(undecoded) > 0 {
+			return nil, &ConfigError{Key: undecoded[0].String(), Err: errors.New("unknown key")}
+		}
+	case ".json":
+		decoder := json.NewDecoder(bytes.NewReader(content))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	default:
+		return nil, fmt.Errorf("config file [%s] has unsupported format [%s]", fileName, extension)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file [%s]: %w", fileName, err)
+	}
+
+	config.expandVariables(os.Getenv)
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// LoadConfigFromEnv reads the config from environment variables only, see ConfigEnvPrefix
+func LoadConfigFromEnv() (*Config, error) {
+	config := new(Config)
+	if err := config.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Validate checks the config, returning a *ConfigError for the first bad key
+func (config *Config) Validate() error {
+	required := map[string]string{
+		"data_feed_id": config.DataFeedID,
+		"username":     config.Username,
+		"password":     config.Password,
+	}
+	for _, key := range []string{"data_feed_id", "username", "password"} {
+		if strings.TrimSpace(required[key]) == "" {
+			return &ConfigError{Key: key, Err: errors.New("is required")}
+		}
+	}
+	if config.BaseURL != "" {
+		if parsed, err := url.Parse(config.BaseURL); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return &ConfigError{Key: "base_url", Err: fmt.Errorf("[%s] is not an absolute URL", config.BaseURL)}
+		}
+	}
+	nonNegative := []struct {
+		key   string
+		value float64
+	}{
+		{"timeout", float64(config.Timeout)},
+		{"rate_limit.requests_per_second", config.RateLimit.RequestsPerSecond},
+		{"rate_limit.burst", float64(config.RateLimit.Burst)},
+		{"rate_limit.token_requests_per_second", config.RateLimit.TokenRequestsPerSecond},
+		{"rate_limit.token_burst", float64(config.RateLimit.TokenBurst)},
+		{"downloads.queue_size", float64(config.Downloads.QueueSize)},
+		{"sync.interval", float64(config.Sync.Interval)},
+		{"sync.concurrency", float64(config.Sync.Concurrency)},
+	}
+	for _, field := range nonNegative {
+		if field.value < 0 {
+			return &ConfigError{Key: field.key, Err: errors.New("must not be negative")}
+		}
+	}
+	return nil
+}
+
+// Options returns the Api options described by the config
+func (config *Config) Options() []Option {
+	var options []Option
+	if config.BaseURL != "" {
+		options = append(options, WithBaseURL(config.BaseURL))
+	}
+	if config.UserAgent != "" {
+		options = append(options, WithUserAgent(config.UserAgent))
+	}
+	if config.Timeout > 0 {
+		options = append(options, WithTimeout(time.Duration(config.Timeout)))
+	}
+	if config.RateLimit != (RateLimitConfig{}) {
+		options = append(options, WithRateLimit(RateLimit{
+			RequestsPerSecond:      config.RateLimit.RequestsPerSecond,
+			Burst:                  config.RateLimit.Burst,
+			TokenRequestsPerSecond: config.RateLimit.TokenRequestsPerSecond,
+			TokenBurst:             config.RateLimit.TokenBurst,
+			Mode:                   RateLimitBlock,
+		}))
+	}
+	return options
+}
+
+// TokenStorage returns a FileTokenStorage for the config's token file, or nil if none is set
+func (config *Config) TokenStorage() *FileTokenStorage {
+	if config.TokenFile == "" {
+		return nil
+	}
+	ts := new(FileTokenStorage)
+	ts.SetFileName(config.TokenFile)
+	ts.SetDataFeedID(config.DataFeedID)
+	return ts
+}
+
+// NewApi validates the config and returns an Api for it, with options applied
+// after the config's own. The Api keeps its token in the config's token file, if any.
+func (config *Config) NewApi(options ...Option) (*Api, error) {
+	api, _, err := config.newApi(options)
+	return api, err
+}
+
+func (config *Config) newApi(options []Option) (*Api, *FileTokenStorage, error) {
+	if err := config.Validate(); err != nil {
+		return nil, nil, err
+	}
+	api := NewApi(config.DataFeedID, config.Username, config.Password, append(config.Options(), options...)...)
+	ts := config.TokenStorage()
+	if ts != nil {
+		api.SetTokenStorage(ts)
+	}
+	return api, ts, nil
+}
+
+// FileDownloader returns a FileDownloader configured by the config's downloads section
+func (config *Config) FileDownloader() *fileDownloader {
+	queueSize := config.Downloads.QueueSize
+	if queueSize == 0 {
+		queueSize = 1
+	}
+	downloader := FileDownloader(queueSize)
+	if config.Downloads.OutputDirectory != "" {
+		downloader.SetOutputDirectory(config.Downloads.OutputDirectory)
+	}
+	if config.Downloads.MaxWidth > 0 {
+		downloader.SetMaxWidth(config.Downloads.MaxWidth)
+	}
+	if config.Downloads.MaxHeight > 0 {
+		downloader.SetMaxHeight(config.Downloads.MaxHeight)
+	}
+	return downloader
+}
+
+// ConfiguredClient is everything built from a Config by Config.Build
+// Contains:
+// Api: The Api for the config's data feed
+// TokenStorage: The storage of the Api's token, nil if the config has no token file
+// Downloader: The FileDownloader for the feed's media
+// Sync: The config's sync settings
+type ConfiguredClient struct {
+	Api          *Api
+	TokenStorage *FileTokenStorage
+	Downloader   *fileDownloader
+	Sync         SyncConfig
+}
+
+// Build validates the config and creates the Api, its token storage and a FileDownloader in one call
+func (config *Config) Build(options ...Option) (*ConfiguredClient, error) {
+	api, ts, err := config.newApi(options)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfiguredClient{
+		Api:          api,
+		TokenStorage: ts,
+		Downloader:   config.FileDownloader(),
+		Sync:         config.Sync,
+	}, nil
+}
+
+// NewSyncer returns a Syncer for the client's Api using the config's sync concurrency
+func (client *ConfiguredClient) NewSyncer(sink Sink, storage HighWaterMarkStorage) *Syncer {
+	syncer := NewSyncer(client.Api, sink, storage)
+	if client.Sync.Concurrency > 0 {
+		syncer.SetConcurrency(client.Sync.Concurrency)
+	}
+	return syncer
+}
+
+// applyEnv overrides every key found by lookup
+func (config *Config) applyEnv(lookup func(string) (string, bool)) error {
+	return walkConfig(reflect.ValueOf(config).Elem(), "", func(key string, field reflect.Value) error {
+		value, ok := lookup(ConfigEnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_")))
+		if !ok {
+			return nil
+		}
+		if err := setConfigValue(field, value); err != nil {
+			return &ConfigError{Key: key, Err: err}
+		}
+		return nil
+	})
+}
+
+// expandVariables replaces the ${VAR} references in string values with getenv(VAR)
+func (config *Config) expandVariables(getenv func(string) string) {
+	walkConfig(reflect.ValueOf(config).Elem(), "", func(key string, field reflect.Value) error {
+		if field.Kind() == reflect.String {
+			field.SetString(configVariablePattern.ReplaceAllStringFunc(field.String(), func(reference string) string {
+				return getenv(configVariablePattern.FindStringSubmatch(reference)[1])
+			}))
+		}
+		return nil
+	})
+}
+
+// walkConfig calls fn with every leaf field of the struct value, keyed by the
+// path of its json tags
+func walkConfig(value reflect.Value, prefix string, fn func(key string, field reflect.Value) error) error {
+	for i := 0; i < value.NumField(); i++ {
+		key := prefix + value.Type().Field(i).Tag.Get("json")
+		field := value.Field(i)
+		if field.Kind() == reflect.Struct {
+			if err := walkConfig(field, key+".", fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := fn(key, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setConfigValue(field reflect.Value, value string) error {
+	if unmarshaler, ok := field.Addr().Interface().(interface{ UnmarshalText([]byte) error }); ok {
+		return unmarshaler.UnmarshalText([]byte(value))
+	}
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		parsed, err := strconv.ParseInt(value, 10, 0)
+		if err != nil {
+			return fmt.Errorf("[%s] is not an integer", value)
+		}
+		field.SetInt(parsed)
+	case reflect.Uint:
+		parsed, err := strconv.ParseUint(value, 10, 0)
+		if err != nil {
+			return fmt.Errorf("[%s] is not a positive integer", value)
+		}
+		field.SetUint(parsed)
+	case reflect.Float64:
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("[%s] is not a number", value)
+		}
+		field.SetFloat(parsed)
+	default:
+		return fmt.Errorf("unsupported type [%s]", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testConfigFiles = map[string]string{
+	"config.yaml": `
+data_feed_id: ABCDEFG
+username: user
+password: ${VEBRA_TEST_PASSWORD}
+base_url: http://localhost:8080
+timeout: 30s
+token_file: /tmp/token
+rate_limit:
+  requests_per_second: 2
+downloads:
+  output_directory: /tmp/files
+  max_width: 640
+sync:
+  interval: 15m
+  concurrency: 4
+`,
+	"config.toml": `
+data_feed_id = "ABCDEFG"
+username = "user"
+password = "${VEBRA_TEST_PASSWORD}"
+base_url = "http://localhost:8080"
+timeout = "30s"
+token_file = "/tmp/token"
+
+[rate_limit]
+requests_per_second = 2.0
+
+[downloads]
+output_directory = "/tmp/files"
+max_width = 640
+
+[sync]
+interval = "15m"
+concurrency = 4
+`,
+	"config.json": `{
+	"data_feed_id": "ABCDEFG",
+	"username": "user",
+	"password": "${VEBRA_TEST_PASSWORD}",
+	"base_url": "http://localhost:8080",
+	"timeout": "30s",
+	"token_file": "/tmp/token",
+	"rate_limit": {"requests_per_second": 2},
+	"downloads": {"output_directory": "/tmp/files", "max_width": 640},
+	"sync": {"interval": "15m", "concurrency": 4}
+}`,
+}
+
+func writeTestConfig(t *testing.T, name string, content string) string {
+	fileName := filepath.Join(t.TempDir(), name)
+	if err := ioutil.WriteFile(fileName, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return fileName
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	t.Setenv("VEBRA_TEST_PASSWORD", "secret")
+	for name, content := range testConfigFiles {
+		config, err := LoadConfig(writeTestConfig(t, name, content))
+		if err != nil {
+			t.Errorf("Loading [%s] failed: %s", name, err)
+			continue
+		}
+		expected := Config{
+			DataFeedID: "ABCDEFG",
+			Username:   "user",
+			Password:   "secret",
+			BaseURL:    "http://localhost:8080",
+			Timeout:    Duration(30 * time.Second),
+			TokenFile:  "/tmp/token",
+			RateLimit:  RateLimitConfig{RequestsPerSecond: 2},
+			Downloads:  DownloadConfig{OutputDirectory: "/tmp/files", MaxWidth: 640},
+			Sync:       SyncConfig{Interval: Duration(15 * time.Minute), Concurrency: 4},
+		}
+		if *config != expected {
+			t.Errorf("Expected [%+v] from [%s] but found [%+v]", expected, name, *config)
+		}
+	}
+}
+
+func TestLoadConfigEnvironmentOverridesFile(t *testing.T) {
+	t.Setenv("VEBRA_TEST_PASSWORD", "secret")
+	t.Setenv("VEBRA_USERNAME", "other")
+	t.Setenv("VEBRA_DOWNLOADS_MAX_HEIGHT", "480")
+	t.Setenv("VEBRA_SYNC_INTERVAL", "1h")
+
+	config, err := LoadConfig(writeTestConfig(t, "config.yaml", testConfigFiles["config.yaml"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Username != "other" || config.Downloads.MaxHeight != 480 || config.Sync.Interval != Duration(time.Hour) {
+		t.Errorf("Expected the environment to override the file but found [%+v]", *config)
+	}
+}
+
+func TestLoadConfigErrorsNameTheKey(t *testing.T) {
+	var configError *ConfigError
+
+	t.Setenv("VEBRA_TEST_PASSWORD", "")
+	_, err := LoadConfig(writeTestConfig(t, "config.yaml", testConfigFiles["config.yaml"]))
+	if !errors.As(err, &configError) || configError.Key != "password" {
+		t.Errorf("Expected an error for key [password] but found [%v]", err)
+	}
+
+	t.Setenv("VEBRA_TEST_PASSWORD", "secret")
+	t.Setenv("VEBRA_DOWNLOADS_MAX_WIDTH", "wide")
+	_, err = LoadConfig(writeTestConfig(t, "config.yaml", testConfigFiles["config.yaml"]))
+	if !errors.As(err, &configError) || configError.Key != "downloads.max_width" {
+		t.Errorf("Expected an error for key [downloads.max_width] but found [%v]", err)
+	}
+
+	_, err = LoadConfig(writeTestConfig(t, "config.toml", testConfigFiles["config.toml"]+"\n[extra]\nkey = 1\n"))
+	if !errors.As(err, &configError) || configError.Key != "extra" {
+		t.Errorf("Expected an error for key [extra] but found [%v]", err)
+	}
+}
+
+func TestLoadConfigExpandsVariablesAfterDecoding(t *testing.T) {
+	passwords := []string{`pass"word`, "pass#word", "pass: word", "pass\nusername: admin"}
+	for _, password := range passwords {
+		t.Setenv("VEBRA_TEST_PASSWORD", password)
+		for name, content := range testConfigFiles {
+			config, err := LoadConfig(writeTestConfig(t, name, content))
+			if err != nil {
+				t.Errorf("Loading [%s] with password [%q] failed: %s", name, password, err)
+				continue
+			}
+			if config.Password != password || config.Username != "user" {
+				t.Errorf("Expected password [%q] and username [user] from [%s] but found [%q] and [%s]", password, name, config.Password, config.Username)
+			}
+		}
+	}
+}
+
+func TestLoadConfigFromEnv(t *testing.T) {
+	t.Setenv("VEBRA_DATA_FEED_ID", "ABCDEFG")
+	t.Setenv("VEBRA_USERNAME", "user")
+	t.Setenv("VEBRA_PASSWORD", "secret")
+	t.Setenv("VEBRA_BASE_URL", "not a url")
+
+	var configError *ConfigError
+	if _, err := LoadConfigFromEnv(); !errors.As(err, &configError) || configError.Key != "base_url" {
+		t.Errorf("Expected an error for key [base_url] but found [%v]", err)
+	}
+}
+
+func TestConfigBuild(t *testing.T) {
+	config := &Config{
+		DataFeedID: "ABCDEFG",
+		Username:   "user",
+		Password:   "secret",
+		BaseURL:    "http://localhost:8080",
+		Timeout:    Duration(time.Minute),
+		TokenFile:  filepath.Join(t.TempDir(), "token"),
+		Downloads:  DownloadConfig{OutputDirectory: "/tmp/files", MaxWidth: 640},
+		Sync:       SyncConfig{Concurrency: 4},
+	}
+	client, err := config.Build(WithUserAgent("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Api.baseURL != "http://localhost:8080" || client.Api.timeout != time.Minute || client.Api.userAgent != "test" {
+		t.Errorf("Expected the Api to be configured but found [%s] [%s] [%s]", client.Api.baseURL, client.Api.timeout, client.Api.userAgent)
+	}
+	if client.TokenStorage == nil || client.TokenStorage.tokenFileName != config.TokenFile || client.TokenStorage.dataFeedID != "ABCDEFG" {
+		t.Errorf("Expected a token storage for [%s] but found [%+v]", config.TokenFile, client.TokenStorage)
+	}
+	if client.Downloader.outputDirectory != "/tmp/files" || client.Downloader.maxWidth != 640 {
+		t.Errorf("Expected the downloader to be configured but found [%s] [%d]", client.Downloader.outputDirectory, client.Downloader.maxWidth)
+	}
+	if client.Sync.Concurrency != 4 {
+		t.Errorf("Expected sync concurrency [4] but found [%d]", client.Sync.Concurrency)
+	}
+
+	config.Username = ""
+	var configError *ConfigError
+	if _, err := config.Build(); !errors.As(err, &configError) || configError.Key != "username" {
+		t.Errorf("Expected an error for key [username] but found [%v]", err)
+	}
+}
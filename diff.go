@@ -0,0 +1,229 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// ChangeKind classifies a FieldChange
+type ChangeKind string
+
+const (
+	// ChangeModified is any change to a field not covered by a more specific kind
+	ChangeModified ChangeKind = "modified"
+	// ChangeAdded is a paragraph, bullet, file or area added to the property
+	ChangeAdded ChangeKind = "added"
+	// ChangeRemoved is a paragraph, bullet, file or area removed from the property
+	ChangeRemoved        ChangeKind = "removed"
+	ChangePriceReduced   ChangeKind = "price_reduced"
+	ChangePriceIncreased ChangeKind = "price_increased"
+	// ChangeStatusChanged is a change of WebStatus, e.g. from ForSaleOrToLet to ForSaleOrToLetSSTCOrReserved
+	ChangeStatusChanged ChangeKind = "status_changed"
+	ChangeImageAdded    ChangeKind = "image_added"
+	ChangeImageRemoved  ChangeKind = "image_removed"
+)
+
+// FieldChange is one difference between two versions of a Property
+// Contains:
+// Path: The changed field, e.g. Price.Value, Address.Town or Paragraphs[3].Text.
+// Paragraphs, bullets and files are indexed by their ID, areas by position. An
+// element without an ID is indexed by # and its position, e.g. Files[#2], and
+// every element after the first sharing an ID by the ID and its occurrence,
+// e.g. Files[3:2] for the second file with ID 3.
+// Kind: What kind of change it is
+// Old: The old value, nil if there was none
+// New: The new value, nil if there is none
+type FieldChange struct {
+	Path string
+	Kind ChangeKind
+	Old  interface{}
+	New  interface{}
+}
+
+func (change FieldChange) String() string {
+	return fmt.Sprintf("%s %s: [%v] -> [%v]", change.Path, change.Kind, change.Old, change.New)
+}
+
+// listKeys names the ID field matching the elements of a list between two
+// versions of a property. Lists of other types are matched by position.
+var listKeys = map[reflect.Type]string{
+	reflect.TypeOf(Paragraph{}): "ParagraphID",
+	reflect.TypeOf(Bullet{}):    "BulletID",
+	reflect.TypeOf(File{}):      "FileID",
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Diff walks every field of two versions of a property and returns what
+// changed, in field order. A nil property counts as an empty one, so comparing
+// nil with a new listing returns all of its fields.
+func Diff(old, new *Property) []FieldChange {
+	if old == nil {
+		old = &Property{}
+	}
+	if new == nil {
+		new = &Property{}
+	}
+	var changes []FieldChange
+	diffValues(&changes, "", reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem())
+	return changes
+}
+
+func diffValues(changes *[]FieldChange, path string, old reflect.Value, new reflect.Value) {
+	if datetime, ok := sanitizedDatetime(old); ok {
+		newDatetime, _ := sanitizedDatetime(new)
+		diffValues(changes, path, datetime, newDatetime)
+		return
+	}
+	switch old.Kind() {
+	case reflect.Ptr:
+		if old.IsNil() && new.IsNil() {
+			return
+		}
+		if old.IsNil() || new.IsNil() {
+			addChange(changes, path, leafValue(old), leafValue(new))
+			return
+		}
+		diffValues(changes, path, old.Elem(), new.Elem())
+	case reflect.Struct:
+		if old.Type() == timeType {
+			if !old.Interface().(time.Time).Equal(new.Interface().(time.Time)) {
+				addChange(changes, path, old.Interface(), new.Interface())
+			}
+			return
+		}
+		for i := 0; i < old.NumField(); i++ {
+			field := old.Type().Field(i)
+			if field.PkgPath != "" || isBookkeepingField(field) {
+				continue
+			}
+			fieldPath := field.Name
+			if path != "" {
+				fieldPath = path + "." + field.Name
+			}
+			diffValues(changes, fieldPath, old.Field(i), new.Field(i))
+		}
+	case reflect.Slice:
+		diffLists(changes, path, old, new)
+	default:
+		if old.Interface() != new.Interface() {
+			addChange(changes, path, old.Interface(), new.Interface())
+		}
+	}
+}
+
+// diffLists matches the elements of two lists by ID, or by position for lists
+// without one, reporting the unmatched ones as added or removed
+func diffLists(changes *[]FieldChange, path string, old reflect.Value, new reflect.Value) {
+	oldKeys, newKeys := listElementKeys(old), listElementKeys(new)
+	newByKey := make(map[string]int, len(newKeys))
+	for i, key := range newKeys {
+		newByKey[key] = i
+	}
+	matched := make(map[string]bool, len(oldKeys))
+	for i, key := range oldKeys {
+		elementPath := path + "[" + key + "]"
+		j, ok := newByKey[key]
+		if !ok {
+			addListChange(changes, elementPath, ChangeRemoved, old.Index(i).Interface(), nil)
+			continue
+		}
+		matched[key] = true
+		diffValues(changes, elementPath, old.Index(i), new.Index(j))
+	}
+	for j, key := range newKeys {
+		if !matched[key] {
+			addListChange(changes, path+"["+key+"]", ChangeAdded, nil, new.Index(j).Interface())
+		}
+	}
+}
+
+// listElementKeys returns the ID of each element, or its position if it has
+// none. Keys are unique: an ID seen before is suffixed with its occurrence.
+func listElementKeys(list reflect.Value) []string {
+	keys := make([]string, list.Len())
+	keyField, keyed := listKeys[list.Type().Elem()]
+	occurrences := make(map[string]int)
+	for i := range keys {
+		keys[i] = fmt.Sprint(i)
+		if !keyed {
+			continue
+		}
+		id := list.Index(i).FieldByName(keyField)
+		if id.IsNil() {
+			keys[i] = fmt.Sprint("#", i)
+			continue
+		}
+		keys[i] = fmt.Sprint(id.Elem().Interface())
+		if occurrences[keys[i]]++; occurrences[keys[i]] > 1 {
+			keys[i] = fmt.Sprint(keys[i], ":", occurrences[keys[i]])
+		}
+	}
+	return keys
+}
+
+// isBookkeepingField tells whether a field only links the value to its
+// property in the database, like the gorm foreign key PropertyID. Such fields
+// are set on stored copies but not on ones parsed from the API.
+func isBookkeepingField(field reflect.StructField) bool {
+	return field.Name == "PropertyID" || (!field.Anonymous && field.Tag.Get("json") == "-")
+}
+
+// sanitizedDatetime returns the time held by a sanitized date type
+func sanitizedDatetime(value reflect.Value) (reflect.Value, bool) {
+	if value.Kind() != reflect.Struct || value.Type() == timeType {
+		return reflect.Value{}, false
+	}
+	field, ok := value.Type().FieldByName("Datetime")
+	if !ok || field.Type != reflect.PtrTo(timeType) {
+		return reflect.Value{}, false
+	}
+	return value.FieldByIndex(field.Index), true
+}
+
+// leafValue returns what a pointer points to, or nil. A sanitized date is
+// returned as its time.
+func leafValue(value reflect.Value) interface{} {
+	if value.IsNil() {
+		return nil
+	}
+	if datetime, ok := sanitizedDatetime(value.Elem()); ok {
+		return leafValue(datetime)
+	}
+	return value.Elem().Interface()
+}
+
+// addChange records a changed field, telling price and status changes apart
+func addChange(changes *[]FieldChange, path string, old interface{}, new interface{}) {
+	kind := ChangeModified
+	switch path {
+	case "Price.Value":
+		oldPrice, oldOk := old.(SanitizedInt)
+		newPrice, newOk := new.(SanitizedInt)
+		if oldOk && newOk && newPrice < oldPrice {
+			kind = ChangePriceReduced
+		} else if oldOk && newOk {
+			kind = ChangePriceIncreased
+		}
+	case "WebStatus":
+		kind = ChangeStatusChanged
+	}
+	*changes = append(*changes, FieldChange{Path: path, Kind: kind, Old: old, New: new})
+}
+
+// addListChange records an added or removed element, telling images apart
+func addListChange(changes *[]FieldChange, path string, kind ChangeKind, old interface{}, new interface{}) {
+	file, ok := old.(File)
+	if !ok {
+		file, ok = new.(File)
+	}
+	if ok && file.Type == Image {
+		if kind == ChangeAdded {
+			kind = ChangeImageAdded
+		} else {
+			kind = ChangeImageRemoved
+		}
+	}
+	*changes = append(*changes, FieldChange{Path: path, Kind: kind, Old: old, New: new})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func sanitizedInt(i int) *SanitizedInt {
+	value := SanitizedInt(i)
+	return &value
+}
+
+func testDiffProperty() *Property {
+	instructed := time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC)
+	return &Property{
+		ID:         26858499,
+		Address:    Address{Town: "Leeds"},
+		Price:      Price{Qualifier: "Asking Price", Value: sanitizedInt(250000)},
+		WebStatus:  ForSaleOrToLet,
+		Instructed: &SanitizedDateISODate{SanitizedDateType{Datetime: &instructed}},
+		Paragraphs: []Paragraph{
+			{ParagraphID: sanitizedInt(1), Name: "Kitchen", Text: "Fitted"},
+			{ParagraphID: sanitizedInt(2), Name: "Lounge"},
+		},
+		Bullets: []Bullet{{BulletID: sanitizedInt(1), Value: "Garden"}},
+		Files: []File{
+			{FileID: sanitizedInt(0), Type: Image, Url: "http://example.com/0.jpg"},
+			{FileID: sanitizedInt(1), Type: FloorPlan, Url: "http://example.com/1.pdf"},
+		},
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := testDiffProperty()
+	updated := testDiffProperty()
+	updated.Price.Value = sanitizedInt(240000)
+	updated.WebStatus = ForSaleOrToLetSSTCOrReserved
+	updated.Address.Town = "Bradford"
+	instructed := time.Date(2019, 1, 3, 0, 0, 0, 0, time.UTC)
+	updated.Instructed = &SanitizedDateISODate{SanitizedDateType{Datetime: &instructed}}
+	// reordered, with paragraph 1 changed
+	updated.Paragraphs = []Paragraph{
+		{ParagraphID: sanitizedInt(2), Name: "Lounge"},
+		{ParagraphID: sanitizedInt(1), Name: "Kitchen", Text: "Refitted"},
+	}
+	updated.Bullets = nil
+	updated.Files = []File{
+		{FileID: sanitizedInt(1), Type: FloorPlan, Url: "http://example.com/1.pdf"},
+		{FileID: sanitizedInt(2), Type: Image, Url: "http://example.com/2.jpg"},
+	}
+
+	expected := []FieldChange{
+		{"Address.Town", ChangeModified, "Leeds", "Bradford"},
+		{"Price.Value", ChangePriceReduced, SanitizedInt(250000), SanitizedInt(240000)},
+		{"WebStatus", ChangeStatusChanged, ForSaleOrToLet, ForSaleOrToLetSSTCOrReserved},
+		{"Instructed", ChangeModified, old.Instructed.Datetime.UTC(), instructed},
+		{"Paragraphs[1].Text", ChangeModified, "Fitted", "Refitted"},
+		{"Bullets[1]", ChangeRemoved, old.Bullets[0], nil},
+		{"Files[0]", ChangeImageRemoved, old.Files[0], nil},
+		{"Files[2]", ChangeImageAdded, nil, updated.Files[1]},
+	}
+	changes := Diff(old, updated)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected [%d] changes but found [%d]: %v", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.Path != expected[i].Path || change.Kind != expected[i].Kind {
+			t.Errorf("Expected [%s] but found [%s]", expected[i], change)
+		}
+	}
+	if changes[3].Old.(time.Time) != expected[3].Old || changes[3].New.(time.Time) != expected[3].New {
+		t.Errorf("Expected the instruction dates but found [%s]", changes[3])
+	}
+
+	if changes := Diff(old, testDiffProperty()); len(changes) != 0 {
+		t.Errorf("Expected no changes between equal properties but found %v", changes)
+	}
+	updated.Price.Value = sanitizedInt(260000)
+	for _, change := range Diff(old, updated) {
+		if change.Path == "Price.Value" && change.Kind != ChangePriceIncreased {
+			t.Errorf("Expected [%s] but found [%s]", ChangePriceIncreased, change.Kind)
+		}
+	}
+}
+
+func TestDiffIgnoresStoredForeignKeys(t *testing.T) {
+	stored := testDiffProperty()
+	stored.Address.PropertyID = stored.ID
+	stored.Price.PropertyID = stored.ID
+	for i := range stored.Paragraphs {
+		stored.Paragraphs[i].PropertyID = stored.ID
+	}
+	stored.Bullets[0].PropertyID = stored.ID
+	for i := range stored.Files {
+		stored.Files[i].PropertyID = stored.ID
+	}
+
+	if changes := Diff(stored, testDiffProperty()); len(changes) != 0 {
+		t.Errorf("Expected no changes against a stored copy but found %v", changes)
+	}
+	if events := Events(stored, testDiffProperty()); len(events) != 0 {
+		t.Errorf("Expected no events against a stored copy but found %v", eventTypes(events))
+	}
+}
+
+func TestDiffKeepsFilesWithoutOrWithSharedIDs(t *testing.T) {
+	old := testDiffProperty()
+	updated := testDiffProperty()
+	// without an ID, the new image is at position 1, next to file ID 1
+	updated.Files = append([]File{updated.Files[0], {Type: Image, Url: "http://example.com/new.jpg"}}, updated.Files[1:]...)
+	updated.Files = append(updated.Files, File{FileID: sanitizedInt(0), Type: Image, Url: "http://example.com/0-copy.jpg"})
+
+	expected := []FieldChange{
+		{Path: "Files[#1]", Kind: ChangeImageAdded},
+		{Path: "Files[0:2]", Kind: ChangeImageAdded},
+	}
+	changes := Diff(old, updated)
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected [%d] changes but found [%d]: %v", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.Path != expected[i].Path || change.Kind != expected[i].Kind {
+			t.Errorf("Expected [%s] but found [%s]", expected[i], change)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package api
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// TokenKeyEnv names the environment variable holding the base64 encoded
+	// AES key used by EncryptedTokenStorage when no key is given as an option
+	TokenKeyEnv = "VEBRA_TOKEN_KEY"
+	// TokenPreviousKeysEnv names the environment variable holding comma separated,
+	// base64 encoded keys which tokens saved before a key rotation are encrypted with
+	TokenPreviousKeysEnv = "VEBRA_TOKEN_PREVIOUS_KEYS"
+
+	// encryptedTokenVersion is the version of the file format written by EncryptedTokenStorage
+	encryptedTokenVersion = 1
+)
+
+var (
+	// ErrTokenTampered is returned when a stored token fails authentication on Load
+	ErrTokenTampered = errors.New("stored token has been tampered with")
+	// ErrTokenUnreadable is returned when a stored token authenticates but cannot be
+	// decoded, or was written in a format version this package does not support
+	ErrTokenUnreadable = errors.New("stored token cannot be read")
+	// ErrTokenKeyMissing is returned when no key for EncryptedTokenStorage was configured
+	ErrTokenKeyMissing = errors.New("no token encryption key configured")
+)
+
+// EncryptedTokenStorage implements the TokenStorage interface, keeping the token
+// in a file encrypted with AES-GCM. After a key rotation tokens encrypted with a
+// previous key are still loaded, and encrypted with the current key on the next Save.
+type EncryptedTokenStorage struct {
+	tokenFileName string
+	dataFeedID    string
+	key           []byte
+	previousKeys  [][]byte
+}
+
+// EncryptedTokenStorageOption configures an EncryptedTokenStorage created by NewEncryptedTokenStorage
+type EncryptedTokenStorageOption func(ts *EncryptedTokenStorage)
+
+// WithTokenKey sets the AES key, 16, 24 or 32 bytes long, tokens are encrypted with
+func WithTokenKey(key []byte) EncryptedTokenStorageOption {
+	return func(ts *EncryptedTokenStorage) {
+		ts.key = key
+	}
+}
+
+// WithPreviousTokenKeys sets keys which tokens may still be encrypted with after a key rotation
+func WithPreviousTokenKeys(keys ...[]byte) EncryptedTokenStorageOption {
+	return func(ts *EncryptedTokenStorage) {
+		ts.previousKeys = keys
+	}
+}
+
+// WithTokenDataFeedID records the data feed the token belongs to. Loading a token
+// saved for another data feed fails.
+func WithTokenDataFeedID(dataFeedID string) EncryptedTokenStorageOption {
+	return func(ts *EncryptedTokenStorage) {
+		ts.dataFeedID = dataFeedID
+	}
+}
+
+// encryptedToken is the JSON document stored by EncryptedTokenStorage.
+// Ciphertext holds a fileToken sealed with the key identified by KeyID.
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	KeyID      string `json:"key_id"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// NewEncryptedTokenStorage returns an EncryptedTokenStorage keeping the token in fileName.
+// Keys not given as options are read from the TokenKeyEnv and TokenPreviousKeysEnv
+// environment variables.
+func NewEncryptedTokenStorage(fileName string, options ...EncryptedTokenStorageOption) (*EncryptedTokenStorage, error) {
+	ts := &EncryptedTokenStorage{tokenFileName: fileName}
+	for _, option := range options {
+		option(ts)
+	}
+	var err error
+	if ts.key == nil {
+		if ts.key, err = decodeTokenKey(os.Getenv(TokenKeyEnv)); err != nil {
+			return nil, fmt.Errorf("environment variable [%s]: %w", TokenKeyEnv, err)
+		}
+	}
+	if ts.key == nil {
+		return nil, ErrTokenKeyMissing
+	}
+	if ts.previousKeys == nil {
+		for _, encoded := range strings.Split(os.Getenv(TokenPreviousKeysEnv), ",") {
+			key, err := decodeTokenKey(encoded)
+			if err != nil {
+				return nil, fmt.Errorf("environment variable [%s]: %w", TokenPreviousKeysEnv, err)
+			}
+			if key != nil {
+				ts.previousKeys = append(ts.previousKeys, key)
+			}
+		}
+	}
+	for _, key := range append([][]byte{ts.key}, ts.previousKeys...) {
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, err
+		}
+	}
+	return ts, nil
+}
+
+// decodeTokenKey decodes a base64 encoded key, returning nil for an empty string
+func decodeTokenKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// tokenKeyID identifies a key without revealing it
+func tokenKeyID(key []byte) string {
+	hash := sha256.Sum256(key)
+	return hex.EncodeToString(hash[:8])
+}
+
+func newTokenCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Save encrypts the Token with the current key and persists it to a file readable by the current user only
+func (ts *EncryptedTokenStorage) Save(token Token) error {
+	plaintext, err := json.Marshal(newFileToken(token, ts.dataFeedID))
+	if err != nil {
+		return err
+	}
+	aead, err := newTokenCipher(ts.key)
+	if err != nil {
+		return err
+	}
+	stored := &encryptedToken{
+		Version: encryptedTokenVersion,
+		KeyID:   tokenKeyID(ts.key),
+		Nonce:   make([]byte, aead.NonceSize()),
+	}
+	if _, err := io.ReadFull(rand.Reader, stored.Nonce); err != nil {
+		return err
+	}
+	stored.Ciphertext = aead.Seal(nil, stored.Nonce, plaintext, stored.additionalData())
+	content, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ts.tokenFileName, content, 0600)
+}
+
+// Load decrypts the persisted Token, failing with ErrTokenTampered if the file
+// was modified or encrypted with an unknown key, and with ErrTokenUnreadable if
+// it has an unsupported version or cannot be decoded. An Api treats such a token
+// as missing: it logs a warning, renews the token and overwrites the file.
+func (ts *EncryptedTokenStorage) Load() (*Token, error) {
+	content, err := ioutil.ReadFile(ts.tokenFileName)
+	if os.IsNotExist(err) {
+		token := NewToken("")
+		token.Invalidate()
+		return token, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stored := new(encryptedToken)
+	if err := json.Unmarshal(content, stored); err != nil {
+		return nil, fmt.Errorf("token file [%s]: %w", ts.tokenFileName, ErrTokenTampered)
+	}
+	if stored.Version > encryptedTokenVersion {
+		return nil, fmt.Errorf("token file [%s] has unsupported version [%d]: %w", ts.tokenFileName, stored.Version, ErrTokenUnreadable)
+	}
+	key := ts.findKey(stored.KeyID)
+	if key == nil {
+		return nil, fmt.Errorf("token file [%s] is encrypted with unknown key [%s]: %w", ts.tokenFileName, stored.KeyID, ErrTokenTampered)
+	}
+	aead, err := newTokenCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(stored.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("token file [%s]: %w", ts.tokenFileName, ErrTokenTampered)
+	}
+	plaintext, err := aead.Open(nil, stored.Nonce, stored.Ciphertext, stored.additionalData())
+	if err != nil {
+		return nil, fmt.Errorf("token file [%s]: %w", ts.tokenFileName, ErrTokenTampered)
+	}
+
+	token := new(fileToken)
+	if err := json.Unmarshal(plaintext, token); err != nil {
+		return nil, fmt.Errorf("token file [%s] is corrupt: %w: %w", ts.tokenFileName, ErrTokenUnreadable, err)
+	}
+	if err := token.checkDataFeed(ts.tokenFileName, ts.dataFeedID); err != nil {
+		return nil, err
+	}
+	return token.restore(), nil
+}
+
+// findKey returns the current or previous key with the given ID, if any
+func (ts *EncryptedTokenStorage) findKey(keyID string) []byte {
+	for _, key := range append([][]byte{ts.key}, ts.previousKeys...) {
+		if tokenKeyID(key) == keyID {
+			return key
+		}
+	}
+	return nil
+}
+
+// additionalData binds the ciphertext to the file format version and key ID
+func (stored *encryptedToken) additionalData() []byte {
+	return []byte(fmt.Sprintf("vebra-token:%d:%s", stored.Version, stored.KeyID))
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func newTestTokenKey(seed byte) []byte {
+	return bytes.Repeat([]byte{seed}, 32)
+}
+
+func TestEncryptedTokenStorageRoundTrip(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "token")
+	ts, err := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)), WithTokenDataFeedID("ABCDEFG"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := NewToken("token-1")
+	if err := ts.Save(*saved); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(fileName)
+	if bytes.Contains(content, []byte("token-1")) {
+		t.Errorf("Expected the token to be encrypted but found [%s]", content)
+	}
+
+	loaded, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetToken() != "token-1" || !loaded.ExpiresAt().Equal(saved.ExpiresAt()) {
+		t.Errorf("Expected token [token-1] expiring at [%s] but found [%+v]", saved.ExpiresAt(), loaded)
+	}
+}
+
+func TestEncryptedTokenStorageDetectsTampering(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "token")
+	ts, err := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Save(*NewToken("token-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	content, _ := ioutil.ReadFile(fileName)
+	stored := new(encryptedToken)
+	if err := json.Unmarshal(content, stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.Ciphertext[0] ^= 0xff
+	content, _ = json.Marshal(stored)
+	if err := ioutil.WriteFile(fileName, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ts.Load(); !errors.Is(err, ErrTokenTampered) {
+		t.Errorf("Expected [%s] but found [%v]", ErrTokenTampered, err)
+	}
+}
+
+func TestEncryptedTokenStorageRejectsWrongKey(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "token")
+	ts, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)))
+	if err := ts.Save(*NewToken("token-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	other, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(2)))
+	if _, err := other.Load(); !errors.Is(err, ErrTokenTampered) {
+		t.Errorf("Expected [%s] but found [%v]", ErrTokenTampered, err)
+	}
+}
+
+func TestApiReplacesStoredTokenEncryptedWithAnotherKey(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	fileName := filepath.Join(t.TempDir(), "token")
+	other, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(2)))
+	if err := other.Save(*NewToken("token-0")); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)))
+	logger := new(recordingLogger)
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithLogger(logger))
+	api.SetTokenStorage(ts)
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatalf("Expected the unreadable token to be renewed but found [%v]", err)
+	}
+	if found := logger.count(LogTokenDiscarded); found != 1 {
+		t.Errorf("Expected [1] event [%s] but found [%d]", LogTokenDiscarded, found)
+	}
+	if token, err := ts.Load(); err != nil || token.GetToken() != "token-1" {
+		t.Errorf("Expected the file to be overwritten with [token-1] but found [%+v] [%v]", token, err)
+	}
+}
+
+// writeUnreadableTokens writes token files which authenticate but cannot be read
+func writeUnreadableTokens(t *testing.T, key []byte) map[string]string {
+	files := make(map[string]string)
+	for name, stored := range map[string]*encryptedToken{
+		"future version": {Version: encryptedTokenVersion + 1, KeyID: tokenKeyID(key)},
+		"corrupt token":  {Version: encryptedTokenVersion, KeyID: tokenKeyID(key)},
+	} {
+		aead, err := newTokenCipher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored.Nonce = make([]byte, aead.NonceSize())
+		stored.Ciphertext = aead.Seal(nil, stored.Nonce, []byte("not a token"), stored.additionalData())
+		content, _ := json.Marshal(stored)
+		files[name] = filepath.Join(t.TempDir(), "token")
+		if err := ioutil.WriteFile(files[name], content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return files
+}
+
+func TestApiReplacesUnreadableStoredToken(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+
+	for name, fileName := range writeUnreadableTokens(t, newTestTokenKey(1)) {
+		ts, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)))
+		if _, err := ts.Load(); !errors.Is(err, ErrTokenUnreadable) {
+			t.Errorf("[%s] Expected [%s] but found [%v]", name, ErrTokenUnreadable, err)
+		}
+		logger := new(recordingLogger)
+		api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithLogger(logger))
+		api.SetTokenStorage(ts)
+		if _, err := api.GetBranches(); err != nil {
+			t.Errorf("[%s] Expected the unreadable token to be renewed but found [%v]", name, err)
+		}
+		if found := logger.count(LogTokenDiscarded); found != 1 {
+			t.Errorf("[%s] Expected [1] event [%s] but found [%d]", name, LogTokenDiscarded, found)
+		}
+		if token, err := ts.Load(); err != nil || !token.IsValid() {
+			t.Errorf("[%s] Expected the file to be overwritten with a valid token but found [%+v] [%v]", name, token, err)
+		}
+	}
+}
+
+func TestEncryptedTokenStorageKeyRotation(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "token")
+	old, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(1)))
+	if err := old.Save(*NewToken("token-1")); err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(2)), WithPreviousTokenKeys(newTestTokenKey(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := rotated.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rotated.Save(*token); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := NewEncryptedTokenStorage(fileName, WithTokenKey(newTestTokenKey(2)))
+	if token, err := current.Load(); err != nil || token.GetToken() != "token-1" {
+		t.Errorf("Expected the token to be encrypted with the new key but found [%+v] [%v]", token, err)
+	}
+}
+
+func TestEncryptedTokenStorageKeyFromEnvironment(t *testing.T) {
+	t.Setenv(TokenKeyEnv, base64.StdEncoding.EncodeToString(newTestTokenKey(3)))
+	t.Setenv(TokenPreviousKeysEnv, "")
+	fileName := filepath.Join(t.TempDir(), "token")
+	ts, err := NewEncryptedTokenStorage(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(ts.key, newTestTokenKey(3)) {
+		t.Errorf("Expected the key to be read from [%s]", TokenKeyEnv)
+	}
+
+	t.Setenv(TokenKeyEnv, "")
+	if _, err := NewEncryptedTokenStorage(fileName); !errors.Is(err, ErrTokenKeyMissing) {
+		t.Errorf("Expected [%s] but found [%v]", ErrTokenKeyMissing, err)
+	}
+	if _, err := NewEncryptedTokenStorage(fileName, WithTokenKey([]byte("short"))); err == nil {
+		t.Errorf("Expected an error for an invalid key length")
+	}
+}
@@ -0,0 +1,90 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	HeaderRequestIDKey = "X-Request-Id"
+
+	// maxErrorBodyExcerpt is the number of bytes of a failed response body kept on an APIError
+	maxErrorBodyExcerpt = 512
+)
+
+var (
+	// ErrUnauthorized matches an APIError for a 401 response
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound matches an APIError for a 404 response
+	ErrNotFound = errors.New("not found")
+	// ErrPropertyDeleted is returned when asking for a property the feed reports as deleted
+	ErrPropertyDeleted = errors.New("property has been deleted")
+	// ErrRateLimited is returned when a request exceeds the client-side rate limit in RateLimitFail mode
+	ErrRateLimited = errors.New("rate limit exceeded")
+)
+
+// TransportError is returned when a request never produced a response,
+// e.g. DNS failures, refused or reset connections.
+type TransportError struct {
+	URL string
+	Err error
+}
+
+func (e *TransportError) Error() string {
+	return fmt.Sprintf("request to [%s] failed: %s", e.URL, e.Err)
+}
+
+func (e *TransportError) Unwrap() error {
+	return e.Err
+}
+
+// APIError is returned when the API answers with a status code other than 200 or 304.
+// Contains:
+// StatusCode: HTTP status code of the response
+// Status: HTTP status line of the response, e.g. "404 Not Found"
+// URL: the requested URL
+// Body: the first bytes of the response body
+// RequestID: the request ID reported by the server, if any
+type APIError struct {
+	StatusCode int
+	Status     string
+	URL        string
+	Body       string
+	RequestID  string
+}
+
+func (e *APIError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("request to [%s] returned [%s] (request ID [%s])", e.URL, e.Status, e.RequestID)
+	}
+	return fmt.Sprintf("request to [%s] returned [%s]", e.URL, e.Status)
+}
+
+// Is reports whether the APIError matches one of the sentinel errors
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+func newAPIError(response *http.Response) *APIError {
+	defer response.Body.Close()
+	excerpt, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBodyExcerpt))
+	apiError := &APIError{
+		StatusCode: response.StatusCode,
+		Status:     response.Status,
+		Body:       strings.TrimSpace(string(excerpt)),
+		RequestID:  response.Header.Get(HeaderRequestIDKey),
+	}
+	if response.Request != nil {
+		apiError.URL = response.Request.URL.String()
+	}
+	return apiError
+}
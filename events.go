@@ -0,0 +1,263 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType names a change in the lifecycle of a listing
+type EventType string
+
+const (
+	// EventListingAdded is a property appearing in the feed
+	EventListingAdded EventType = "listing_added"
+	// EventListingWithdrawn is a property deleted from the feed
+	EventListingWithdrawn EventType = "listing_withdrawn"
+	EventPriceReduced     EventType = "price_reduced"
+	EventPriceIncreased   EventType = "price_increased"
+	// EventStatusChanged is a change of WebStatus, e.g. to let agreed or sold STC
+	EventStatusChanged EventType = "status_changed"
+	// EventMediaChanged is any change to a property's images, floor plans, tours and other files
+	EventMediaChanged EventType = "media_changed"
+)
+
+// Event describes a change to a listing
+// Contains:
+// Type: What happened
+// PropertyID: The property's ID
+// Property: The property after the change, nil when it was withdrawn
+// Previous: The property before the change, nil when it was added
+// Changes: The field changes the event was derived from, see Diff
+// OccurredAt: When the change was noticed
+type Event struct {
+	Type       EventType
+	PropertyID uint
+	Property   *Property
+	Previous   *Property
+	Changes    []FieldChange
+	OccurredAt time.Time
+}
+
+// newInstructionStatuses are the statuses of a listing newly on the market
+var newInstructionStatuses = map[PropertyStatus]bool{
+	ForSaleOrToLet:               true,
+	ForSaleOrToLetNewInstruction: true,
+	ForSaleOrToLetJustOnMarket:   true,
+	LetingsToLet:                 true,
+}
+
+// IsNewInstruction tells whether the event is a listing coming to the market:
+// added while for sale or to let, or given the status ForSaleOrToLetNewInstruction
+// or ForSaleOrToLetJustOnMarket. Listings added as sold, let or under offer, as
+// when a sync fills an empty sink, are not new instructions.
+func (event Event) IsNewInstruction() bool {
+	if event.Property == nil {
+		return false
+	}
+	switch event.Type {
+	case EventListingAdded:
+		return newInstructionStatuses[event.Property.WebStatus]
+	case EventStatusChanged:
+		return event.Property.WebStatus == ForSaleOrToLetNewInstruction || event.Property.WebStatus == ForSaleOrToLetJustOnMarket
+	}
+	return false
+}
+
+// IsPriceReduction tells whether the event is a lower price, or the listing
+// being given the status ForSaleOrToLetPriceReduction
+func (event Event) IsPriceReduction() bool {
+	switch event.Type {
+	case EventPriceReduced:
+		return true
+	case EventStatusChanged:
+		return event.Property != nil && event.Property.WebStatus == ForSaleOrToLetPriceReduction
+	}
+	return false
+}
+
+// Events derives the events between two versions of a property. A nil previous
+// property means the listing was added, a nil current one that it was withdrawn.
+func Events(previous, current *Property) []Event {
+	now := time.Now()
+	switch {
+	case previous == nil && current == nil:
+		return nil
+	case previous == nil:
+		return []Event{{Type: EventListingAdded, PropertyID: current.ID, Property: current, OccurredAt: now}}
+	case current == nil:
+		return []Event{{Type: EventListingWithdrawn, PropertyID: previous.ID, Previous: previous, OccurredAt: now}}
+	}
+
+	var events []Event
+	var media []FieldChange
+	for _, change := range Diff(previous, current) {
+		var eventType EventType
+		switch {
+		case change.Kind == ChangePriceReduced:
+			eventType = EventPriceReduced
+		case change.Kind == ChangePriceIncreased:
+			eventType = EventPriceIncreased
+		case change.Kind == ChangeStatusChanged:
+			eventType = EventStatusChanged
+		case strings.HasPrefix(change.Path, "Files["):
+			media = append(media, change)
+			continue
+		default:
+			continue
+		}
+		events = append(events, Event{Type: eventType, PropertyID: current.ID, Property: current, Previous: previous,
+			Changes: []FieldChange{change}, OccurredAt: now})
+	}
+	if len(media) > 0 {
+		events = append(events, Event{Type: EventMediaChanged, PropertyID: current.ID, Property: current, Previous: previous,
+			Changes: media, OccurredAt: now})
+	}
+	return events
+}
+
+// Subscriber handles the events published on an EventBus
+type Subscriber interface {
+	HandleEvent(event Event) error
+}
+
+// SubscriberFunc lets an ordinary function be a Subscriber
+type SubscriberFunc func(event Event) error
+
+// HandleEvent calls fn(event)
+func (fn SubscriberFunc) HandleEvent(event Event) error {
+	return fn(event)
+}
+
+type subscription struct {
+	id         int
+	subscriber Subscriber
+	types      map[EventType]bool
+}
+
+// EventBus fans events out to its subscribers. It is safe for concurrent use.
+type EventBus struct {
+	mutex         sync.Mutex
+	subscriptions []subscription
+	nextID        int
+}
+
+// NewEventBus returns an EventBus without subscribers
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe hands the events of the given types, or of every type if none are
+// given, to subscriber until the returned function is called
+func (bus *EventBus) Subscribe(subscriber Subscriber, types ...EventType) (unsubscribe func()) {
+	bus.mutex.Lock()
+	defer bus.mutex.Unlock()
+	bus.nextID++
+	added := subscription{id: bus.nextID, subscriber: subscriber}
+	if len(types) > 0 {
+		added.types = make(map[EventType]bool, len(types))
+		for _, eventType := range types {
+			added.types[eventType] = true
+		}
+	}
+	bus.subscriptions = append(bus.subscriptions, added)
+	return func() {
+		bus.mutex.Lock()
+		defer bus.mutex.Unlock()
+		for i, subscribed := range bus.subscriptions {
+			if subscribed.id == added.id {
+				bus.subscriptions = append(bus.subscriptions[:i:i], bus.subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// Publish hands every event to each subscriber of its type, in the order they
+// subscribed. A failing subscriber does not keep the event from the others,
+// and the errors of all of them are returned joined.
+func (bus *EventBus) Publish(events ...Event) error {
+	bus.mutex.Lock()
+	subscriptions := append([]subscription(nil), bus.subscriptions...)
+	bus.mutex.Unlock()
+
+	var errs []error
+	for _, event := range events {
+		for _, subscribed := range subscriptions {
+			if subscribed.types != nil && !subscribed.types[event.Type] {
+				continue
+			}
+			if err := subscribed.subscriber.HandleEvent(event); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LookupSink is a Sink that can return the copy of a property it holds, as
+// needed by an EventSink
+type LookupSink interface {
+	Sink
+	// Lookup returns nil without an error when the sink does not hold the property
+	Lookup(propertyID uint) (*Property, error)
+}
+
+// EventSink publishes the events of every change passed to a Syncer or
+// Reconciler before handing the change on to the sink it wraps. A Reconciler
+// needs the wrapped sink to be an InventorySink. If publishing fails, the change
+// is not applied, so it is retried and its events are published again:
+// subscribers see every event at least once. Subscribers are called
+// concurrently when the Syncer applies several properties at once.
+type EventSink struct {
+	sink LookupSink
+	bus  *EventBus
+}
+
+// NewEventSink returns an EventSink publishing on bus the changes applied to sink
+func NewEventSink(sink LookupSink, bus *EventBus) *EventSink {
+	return &EventSink{sink: sink, bus: bus}
+}
+
+// Upsert publishes how property differs from the sink's copy, then upserts it
+func (eventSink *EventSink) Upsert(property *Property) error {
+	previous, err := eventSink.sink.Lookup(property.ID)
+	if err != nil {
+		return err
+	}
+	if err = eventSink.bus.Publish(Events(previous, property)...); err != nil {
+		return err
+	}
+	return eventSink.sink.Upsert(property)
+}
+
+// Delete publishes the withdrawal of a property the sink holds, then deletes it
+func (eventSink *EventSink) Delete(propertyID uint) error {
+	previous, err := eventSink.sink.Lookup(propertyID)
+	if err != nil {
+		return err
+	}
+	if err = eventSink.bus.Publish(Events(previous, nil)...); err != nil {
+		return err
+	}
+	return eventSink.sink.Delete(propertyID)
+}
+
+// Records lists the properties held by the wrapped sink if it is an InventorySink
+func (eventSink *EventSink) Records() ([]SinkRecord, error) {
+	inventory, ok := eventSink.sink.(InventorySink)
+	if !ok {
+		return nil, fmt.Errorf("sink [%T] cannot list the properties it holds", eventSink.sink)
+	}
+	return inventory.Records()
+}
+
+// Commit commits the wrapped sink if it is a BatchSink
+func (eventSink *EventSink) Commit() error {
+	if batch, ok := eventSink.sink.(BatchSink); ok {
+		return batch.Commit()
+	}
+	return nil
+}
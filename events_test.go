@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func eventTypes(events []Event) []EventType {
+	types := make([]EventType, len(events))
+	for i, event := range events {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestEvents(t *testing.T) {
+	old := testDiffProperty()
+	updated := testDiffProperty()
+	updated.Price.Value = sanitizedInt(240000)
+	updated.WebStatus = ForSaleOrToLetSSTCOrReserved
+	updated.Files = append(updated.Files, File{FileID: sanitizedInt(2), Type: Image})
+	updated.Files[1].Url = "http://example.com/1-new.pdf"
+
+	events := Events(old, updated)
+	expected := []EventType{EventPriceReduced, EventStatusChanged, EventMediaChanged}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected events %v but found %v", expected, eventTypes(events))
+	}
+	for i, event := range events {
+		if event.Type != expected[i] || event.PropertyID != old.ID || event.Previous != old || event.Property != updated {
+			t.Errorf("Expected [%s] for property [%d] but found [%+v]", expected[i], old.ID, event)
+		}
+	}
+	if len(events[2].Changes) != 2 {
+		t.Errorf("Expected both file changes in [%s] but found %v", EventMediaChanged, events[2].Changes)
+	}
+	if !events[0].IsPriceReduction() || events[0].IsNewInstruction() {
+		t.Errorf("Expected [%s] to be a price reduction only", events[0].Type)
+	}
+
+	if events := Events(nil, updated); len(events) != 1 || events[0].Type != EventListingAdded || events[0].IsNewInstruction() {
+		t.Errorf("Expected [%s] of a listing sold STC but found %v", EventListingAdded, eventTypes(events))
+	}
+	if events := Events(old, nil); len(events) != 1 || events[0].Type != EventListingWithdrawn {
+		t.Errorf("Expected [%s] but found %v", EventListingWithdrawn, eventTypes(events))
+	}
+	updated = testDiffProperty()
+	updated.WebStatus = ForSaleOrToLetNewInstruction
+	if events := Events(old, updated); len(events) != 1 || !events[0].IsNewInstruction() {
+		t.Errorf("Expected a status change to be a new instruction but found %v", eventTypes(events))
+	}
+
+	// backfilling a listing that is no longer on the market is no new instruction
+	updated = testDiffProperty()
+	updated.WebStatus = ForSaleOrToLetSoldOrUnderOffer
+	if events := Events(nil, updated); len(events) != 1 || events[0].IsNewInstruction() {
+		t.Errorf("Expected a listing added as sold not to be a new instruction but found %v", eventTypes(events))
+	}
+	updated.WebStatus = LetingsToLet
+	if events := Events(nil, updated); len(events) != 1 || !events[0].IsNewInstruction() {
+		t.Errorf("Expected a listing added as to let to be a new instruction but found %v", eventTypes(events))
+	}
+}
+
+func TestEventBusFansOut(t *testing.T) {
+	bus := NewEventBus()
+	var all, reductions []EventType
+	bus.Subscribe(SubscriberFunc(func(event Event) error {
+		all = append(all, event.Type)
+		return errors.New("failed")
+	}))
+	unsubscribe := bus.Subscribe(SubscriberFunc(func(event Event) error {
+		reductions = append(reductions, event.Type)
+		return nil
+	}), EventPriceReduced)
+
+	err := bus.Publish(Event{Type: EventListingAdded}, Event{Type: EventPriceReduced})
+	if len(all) != 2 || len(reductions) != 1 || reductions[0] != EventPriceReduced {
+		t.Errorf("Expected [2] events for all and [1] reduction but found %v and %v", all, reductions)
+	}
+	if err == nil {
+		t.Errorf("Expected the subscriber errors to be returned")
+	}
+
+	unsubscribe()
+	bus.Publish(Event{Type: EventPriceReduced})
+	if len(all) != 3 || len(reductions) != 1 {
+		t.Errorf("Expected no events after unsubscribing but found %v", reductions)
+	}
+}
+
+// lookupSink is a memorySink returning the properties it holds
+type lookupSink struct {
+	*memorySink
+}
+
+func (sink *lookupSink) Lookup(propertyID uint) (*Property, error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	return sink.properties[propertyID], nil
+}
+
+func TestEventSinkPublishesSyncedChanges(t *testing.T) {
+	server := newSyncStub(changedPropertiesBody("1:updated", "2:updated", "4:deleted"))
+	defer server.Close()
+
+	sink := &lookupSink{newMemorySink()}
+	sink.properties[2] = &Property{ID: 2, Address: Address{Town: "Leeds"}}
+	sink.properties[4] = &Property{ID: 4}
+	bus := NewEventBus()
+	var mutex sync.Mutex
+	events := make(map[uint][]EventType)
+	bus.Subscribe(SubscriberFunc(func(event Event) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events[event.PropertyID] = append(events[event.PropertyID], event.Type)
+		return nil
+	}))
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	storage := NewFileHighWaterMarkStorage(t.TempDir() + "/mark")
+	syncer := NewSyncer(api, NewEventSink(sink, bus), storage).SetStartTime(time.Now().Add(-time.Hour))
+	if _, err := syncer.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || len(events[1]) != 1 || events[1][0] != EventListingAdded || len(events[4]) != 1 || events[4][0] != EventListingWithdrawn {
+		t.Errorf("Expected property [1] to be added and [4] withdrawn only but found %v", events)
+	}
+}
+
+// inventoryLookupSink is an inventorySink returning the properties it holds
+type inventoryLookupSink struct {
+	*inventorySink
+}
+
+func (sink *inventoryLookupSink) Lookup(propertyID uint) (*Property, error) {
+	return (&lookupSink{sink.memorySink}).Lookup(propertyID)
+}
+
+func TestEventSinkPublishesReconciledChanges(t *testing.T) {
+	server := newReconcileStub()
+	defer server.Close()
+
+	sink := &inventoryLookupSink{&inventorySink{memorySink: newMemorySink()}}
+	sink.properties[2] = &Property{ID: 2}
+	sink.properties[4] = &Property{ID: 4}
+	bus := NewEventBus()
+	var mutex sync.Mutex
+	events := make(map[uint][]EventType)
+	bus.Subscribe(SubscriberFunc(func(event Event) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		events[event.PropertyID] = append(events[event.PropertyID], event.Type)
+		return nil
+	}))
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	report, err := NewReconciler(api, NewEventSink(sink, bus)).SetAutoFix(true).Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Outcomes) != 3 || len(events) != 3 || events[1][0] != EventListingAdded || events[3][0] != EventListingAdded || events[4][0] != EventListingWithdrawn {
+		t.Errorf("Expected properties [1] and [3] to be added and [4] withdrawn but found %v", events)
+	}
+
+	if _, err = NewEventSink(&lookupSink{newMemorySink()}, bus).Records(); err == nil {
+		t.Errorf("Expected an error listing the records of a sink without an inventory")
+	}
+}
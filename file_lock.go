@@ -0,0 +1,24 @@
+package api
+
+import (
+	"fmt"
+	"os"
+)
+
+// lockBeside takes an exclusive advisory lock on a file next to fileName. Files
+// replaced on every write, like token and checkpoint files, cannot carry the
+// lock themselves.
+func lockBeside(fileName string) (unlock func(), err error) {
+	file, err := os.OpenFile(fileName+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("locking file [%s]: %w", fileName, err)
+	}
+	return func() {
+		unlockFile(file)
+		file.Close()
+	}, nil
+}
@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package api
+
+import "os"
+
+// fileLocking reports whether lockFile serialises processes on this platform
+const fileLocking = false
+
+// lockFile does nothing on platforms without flock; processes sharing a locked
+// file there are not serialised
+func lockFile(file *os.File) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
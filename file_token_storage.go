@@ -1,16 +1,55 @@
 package api
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"time"
 )
 
+// fileTokenVersion is the version of the token file format written by FileTokenStorage
+const fileTokenVersion = 1
+
 // FileTokenStorage implements the TokenStorage interface.
 // It is one possible implementation for storing user credentials.
 type FileTokenStorage struct {
 	token         string
 	tokenFileName string
+	dataFeedID    string
+}
+
+// fileToken is the JSON document stored by FileTokenStorage
+type fileToken struct {
+	Version    int       `json:"version"`
+	Token      string    `json:"token"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	DataFeedID string    `json:"data_feed_id,omitempty"`
+}
+
+func newFileToken(token Token, dataFeedID string) *fileToken {
+	return &fileToken{
+		Version:    fileTokenVersion,
+		Token:      token.tokenString,
+		AcquiredAt: token.timeSet,
+		ExpiresAt:  token.expiresAt,
+		DataFeedID: dataFeedID,
+	}
+}
+
+func (stored *fileToken) restore() *Token {
+	return RestoreToken(stored.Token, stored.AcquiredAt, stored.ExpiresAt)
+}
+
+// checkDataFeed fails if the token stored in fileName was saved for another data feed
+func (stored *fileToken) checkDataFeed(fileName string, dataFeedID string) error {
+	if dataFeedID != "" && stored.DataFeedID != "" && stored.DataFeedID != dataFeedID {
+		return fmt.Errorf("token file [%s] belongs to data feed [%s], not [%s]", fileName, stored.DataFeedID, dataFeedID)
+	}
+	return nil
 }
 
 // SetFileName sets the file name used to store the Token in
@@ -18,21 +57,107 @@ func (ts *FileTokenStorage) SetFileName(path string) {
 	ts.tokenFileName = path
 }
 
-// Save persists the Token to a file
+// SetDataFeedID records the data feed the Token belongs to. Loading a token
+// saved for another data feed fails.
+func (ts *FileTokenStorage) SetDataFeedID(dataFeedID string) {
+	ts.dataFeedID = dataFeedID
+}
+
+// Save persists the Token to a file readable by the current user only. Processes
+// sharing the file are serialised with an advisory lock, and a token acquired
+// before the one already stored is not written, so concurrent workers settle on
+// the newest token.
 func (ts *FileTokenStorage) Save(token Token) error {
-	encodedToken := base64.StdEncoding.EncodeToString([]byte(token.tokenString))
-	ts.token = encodedToken
-	return ioutil.WriteFile(ts.tokenFileName, []byte(encodedToken), 0644)
+	unlock, err := ts.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if stored, err := ts.load(); err == nil && stored.IsValid() && stored.AcquiredAt().After(token.AcquiredAt()) {
+		return nil
+	}
+	return ts.write(token)
 }
 
-// Load loads the persisted Token from file
+// write must be called with the lock held
+func (ts *FileTokenStorage) write(token Token) error {
+	content, err := json.Marshal(newFileToken(token, ts.dataFeedID))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(ts.tokenFileName, content, 0600); err != nil {
+		return err
+	}
+	ts.token = token.tokenString
+	return nil
+}
+
+// Load loads the persisted Token from file. Files written by earlier versions,
+// holding just the base64 encoded token, are read and rewritten in the current format.
 func (ts *FileTokenStorage) Load() (*Token, error) {
-	if _,err := os.Stat(ts.tokenFileName); err == nil {
-		out, err := ioutil.ReadFile(ts.tokenFileName)
-		ts.token = string(out)
-		return NewToken(ts.token), err
-	}
-	token := NewToken("")
-	token.Invalidate()
-	return token, nil
+	unlock, err := ts.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ts.load()
+}
+
+// load must be called with the lock held
+func (ts *FileTokenStorage) load() (*Token, error) {
+	info, err := os.Stat(ts.tokenFileName)
+	if os.IsNotExist(err) {
+		token := NewToken("")
+		token.Invalidate()
+		return token, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(ts.tokenFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	content = bytes.TrimSpace(content)
+	if len(content) > 0 && content[0] != '{' {
+		token, err := ts.loadLegacy(content, info.ModTime())
+		if err != nil {
+			return nil, err
+		}
+		// failing to migrate is harmless, the file is rewritten with the next token
+		ts.write(*token)
+		return token, nil
+	}
+
+	stored := new(fileToken)
+	if len(content) > 0 {
+		if err := json.Unmarshal(content, stored); err != nil {
+			return nil, fmt.Errorf("token file [%s] is corrupt: %w", ts.tokenFileName, err)
+		}
+		if stored.Version > fileTokenVersion {
+			return nil, fmt.Errorf("token file [%s] has unsupported version [%d]", ts.tokenFileName, stored.Version)
+		}
+	}
+	if err := stored.checkDataFeed(ts.tokenFileName, ts.dataFeedID); err != nil {
+		return nil, err
+	}
+	ts.token = stored.Token
+	return stored.restore(), nil
+}
+
+// lock serialises the processes sharing the token file
+func (ts *FileTokenStorage) lock() (unlock func(), err error) {
+	return lockBeside(ts.tokenFileName)
+}
+
+// loadLegacy reads a file holding the base64 encoded token, taking it to be
+// acquired when the file was last written
+func (ts *FileTokenStorage) loadLegacy(content []byte, modTime time.Time) (*Token, error) {
+	decoded, err := base64.StdEncoding.DecodeString(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("token file [%s] is corrupt: %w", ts.tokenFileName, err)
+	}
+	ts.token = string(decoded)
+	return RestoreToken(ts.token, modTime, modTime.Add(TokenLifetime)), nil
 }
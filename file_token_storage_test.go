@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const (
+	tokenWriters     = 4
+	tokenWriterSaves = 25
+)
+
+func newTestFileTokenStorage(t *testing.T) *FileTokenStorage {
+	ts := new(FileTokenStorage)
+	ts.SetFileName(filepath.Join(t.TempDir(), "token"))
+	ts.SetDataFeedID("ABCDEFG")
+	return ts
+}
+
+func TestFileTokenStorageRoundTrip(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	saved := NewToken("token-1")
+	if err := ts.Save(*saved); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetToken() != "token-1" || !loaded.IsValid() {
+		t.Errorf("Expected valid token [token-1] but found [%+v]", loaded)
+	}
+	if !loaded.AcquiredAt().Equal(saved.AcquiredAt()) || !loaded.ExpiresAt().Equal(saved.ExpiresAt()) {
+		t.Errorf("Expected acquired [%s] and expiry [%s] but found [%s] and [%s]",
+			saved.AcquiredAt(), saved.ExpiresAt(), loaded.AcquiredAt(), loaded.ExpiresAt())
+	}
+
+	info, err := os.Stat(ts.tokenFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("Expected permissions [0600] but found [%o]", perm)
+	}
+	content, _ := ioutil.ReadFile(ts.tokenFileName)
+	stored := new(fileToken)
+	if err := json.Unmarshal(content, stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Version != fileTokenVersion || stored.DataFeedID != "ABCDEFG" {
+		t.Errorf("Expected version [%d] for data feed [ABCDEFG] but found [%+v]", fileTokenVersion, stored)
+	}
+}
+
+func TestFileTokenStorageLoadMissingFile(t *testing.T) {
+	token, err := newTestFileTokenStorage(t).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.IsValid() {
+		t.Errorf("Expected an invalid token but found [%+v]", token)
+	}
+}
+
+func TestFileTokenStorageLoadExpiredToken(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	acquiredAt := time.Now().Add(-2 * TokenLifetime)
+	if err := ts.Save(*RestoreToken("token-1", acquiredAt, acquiredAt.Add(TokenLifetime))); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.IsValid() || !token.IsExpired() {
+		t.Errorf("Expected an expired token but found [%+v]", token)
+	}
+}
+
+func TestFileTokenStorageMigratesLegacyFile(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	legacy := base64.StdEncoding.EncodeToString([]byte("token-1"))
+	if err := ioutil.WriteFile(ts.tokenFileName, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.GetToken() != "token-1" || !token.IsValid() {
+		t.Errorf("Expected valid token [token-1] but found [%+v]", token)
+	}
+
+	content, _ := ioutil.ReadFile(ts.tokenFileName)
+	stored := new(fileToken)
+	if err := json.Unmarshal(content, stored); err != nil {
+		t.Fatalf("Expected the legacy file to be rewritten as JSON but found [%s]", content)
+	}
+	if stored.Token != "token-1" || stored.ExpiresAt.IsZero() {
+		t.Errorf("Expected token [token-1] with expiry but found [%+v]", stored)
+	}
+}
+
+func TestFileTokenStorageRejectsOtherDataFeed(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	if err := ts.Save(*NewToken("token-1")); err != nil {
+		t.Fatal(err)
+	}
+	ts.SetDataFeedID("HIJKLMN")
+	if _, err := ts.Load(); err == nil {
+		t.Errorf("Expected an error loading the token of another data feed")
+	}
+}
+
+func TestFileTokenStorageRejectsUnknownVersion(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	if err := ioutil.WriteFile(ts.tokenFileName, []byte(`{"version":99,"token":"token-1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Load(); err == nil {
+		t.Errorf("Expected an error loading an unsupported version")
+	}
+}
+
+func TestFileTokenStorageKeepsNewerToken(t *testing.T) {
+	ts := newTestFileTokenStorage(t)
+	newer := NewToken("newer")
+	older := RestoreToken("older", newer.AcquiredAt().Add(-time.Minute), newer.ExpiresAt().Add(-time.Minute))
+	if err := ts.Save(*newer); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Save(*older); err != nil {
+		t.Fatal(err)
+	}
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.GetToken() != "newer" {
+		t.Errorf("Expected [newer] but found [%s]", token.GetToken())
+	}
+}
+
+// TestFileTokenStorageWriterProcess saves a series of tokens when run as one of
+// the processes started by TestFileTokenStorageConcurrentWriters
+func TestFileTokenStorageWriterProcess(t *testing.T) {
+	fileName := os.Getenv("VEBRA_TEST_TOKEN_FILE")
+	if fileName == "" {
+		t.Skip("only run as a writer process")
+	}
+	writer, _ := strconv.Atoi(os.Getenv("VEBRA_TEST_TOKEN_WRITER"))
+	base, _ := strconv.ParseInt(os.Getenv("VEBRA_TEST_TOKEN_BASE"), 10, 64)
+
+	ts := new(FileTokenStorage)
+	ts.SetFileName(fileName)
+	for i := 0; i < tokenWriterSaves; i++ {
+		acquiredAt := time.Unix(0, base).Add(time.Duration(i*tokenWriters+writer) * time.Millisecond)
+		token := RestoreToken(fmt.Sprintf("token-%d-%d", writer, i), acquiredAt, acquiredAt.Add(TokenLifetime))
+		if err := ts.Save(*token); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileTokenStorageConcurrentWriters(t *testing.T) {
+	if !fileLocking {
+		t.Skip("file locking is not supported on this platform")
+	}
+	fileName := filepath.Join(t.TempDir(), "token")
+	base := time.Now().UnixNano()
+
+	commands := make([]*exec.Cmd, tokenWriters)
+	for writer := range commands {
+		command := exec.Command(os.Args[0], "-test.run=^TestFileTokenStorageWriterProcess$")
+		command.Env = append(os.Environ(),
+			"VEBRA_TEST_TOKEN_FILE="+fileName,
+			fmt.Sprintf("VEBRA_TEST_TOKEN_WRITER=%d", writer),
+			fmt.Sprintf("VEBRA_TEST_TOKEN_BASE=%d", base))
+		if err := command.Start(); err != nil {
+			t.Fatal(err)
+		}
+		commands[writer] = command
+	}
+	for writer, command := range commands {
+		if err := command.Wait(); err != nil {
+			t.Errorf("Writer [%d] failed: %s", writer, err)
+		}
+	}
+
+	ts := new(FileTokenStorage)
+	ts.SetFileName(fileName)
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := fmt.Sprintf("token-%d-%d", tokenWriters-1, tokenWriterSaves-1); token.GetToken() != expected {
+		t.Errorf("Expected the newest token [%s] but found [%s]", expected, token.GetToken())
+	}
+}
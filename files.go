@@ -1,6 +1,7 @@
 package api
 
 import (
+	"context"
 	"os"
 	"io"
 	"net/http"
@@ -10,6 +11,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"image"
+	"time"
 )
 
 func CreatePropertyFilesManifest(property *Property) *propertyFilesManifest {
@@ -20,6 +22,7 @@ func CreatePropertyFilesManifest(property *Property) *propertyFilesManifest {
 	return &propertyFilesManifest{
 		strconv.Itoa(int(property.ID)),
 		urls,
+		nil,
 	}
 }
 
@@ -39,6 +42,7 @@ func CreatePropertyFilesManifestFromChangedPropertySummaries(property *ChangedFi
 		manifests = append(manifests, &propertyFilesManifest{
 			key,
 			urls[key],
+			nil,
 		})
 	}
 	return manifests
@@ -47,6 +51,15 @@ func CreatePropertyFilesManifestFromChangedPropertySummaries(property *ChangedFi
 type propertyFilesManifest struct {
 	propertyID string
 	urls []string
+	ctx        context.Context
+}
+
+// context returns the context the manifest was queued with by DownloadContext
+func (manifest *propertyFilesManifest) context() context.Context {
+	if manifest.ctx == nil {
+		return context.Background()
+	}
+	return manifest.ctx
 }
 
 type fileDownloader struct {
@@ -57,6 +70,9 @@ type fileDownloader struct {
 	thumbNailFilePrefix   string
 	maxHeight             uint
 	maxWidth              uint
+	retryPolicy           RetryPolicy
+	metrics               *Metrics
+	tracer                Tracer
 }
 
 func FileDownloader(chanSize int) *fileDownloader {
@@ -68,6 +84,9 @@ func FileDownloader(chanSize int) *fileDownloader {
 		"tn",
 		355,
 		0,
+		DefaultRetryPolicy(),
+		nil,
+		nil,
 	}
 	go fileDownloader.listenForImages()
 	return fileDownloader
@@ -89,11 +108,30 @@ func (fileDownloader *fileDownloader) SetMaxWidth(maxWidth uint) {
 	fileDownloader.maxWidth = maxWidth
 }
 
+// SetRetryPolicy sets how failed media downloads are retried
+func (fileDownloader *fileDownloader) SetRetryPolicy(retryPolicy RetryPolicy) {
+	fileDownloader.retryPolicy = retryPolicy
+}
+
+// SetMetrics records downloaded bytes, failed downloads, thumbnail generation
+// time and the depth of the download queue in metrics
+func (fileDownloader *fileDownloader) SetMetrics(metrics *Metrics) {
+	fileDownloader.metrics = metrics
+	metrics.addQueue(func() int { return len(fileDownloader.propertyChan) })
+}
+
 func (fileDownloader *fileDownloader) Download(property *propertyFilesManifest) {
 	fileDownloader.propertyChan <- property
 	fileDownloader.wg.Add(1)
 }
 
+// DownloadContext is like Download but downloads the media within ctx, so the
+// downloads are traced as children of the span in ctx and stop when ctx is done
+func (fileDownloader *fileDownloader) DownloadContext(ctx context.Context, property *propertyFilesManifest) {
+	property.ctx = ctx
+	fileDownloader.Download(property)
+}
+
 func (fileDownloader *fileDownloader) Wait() {
 	fileDownloader.wg.Wait()
 }
@@ -101,6 +139,9 @@ func (fileDownloader *fileDownloader) Wait() {
 func (fileDownloader *fileDownloader) listenForImages() {
 	for {
 		property := <-fileDownloader.propertyChan
+		propertyID, _ := strconv.Atoi(property.propertyID)
+		ctx, span := startSpan(property.context(), fileDownloader.tracer, "vebra download property",
+			AttributePropertyID.Int(propertyID))
 		for _, file := range property.urls {
 			dir := fileDownloader.outputDirectory + "/" + property.propertyID + "/"
 			if _, err := os.Stat(dir); os.IsNotExist(err) {
@@ -108,30 +149,79 @@ func (fileDownloader *fileDownloader) listenForImages() {
 			}
 			rawFileName := filepath.Base(file)
 			outFile := dir + rawFileName
-			fileDownloader.downloadFile(file, outFile)
+			fileDownloader.downloadFile(ctx, file, outFile)
 			thumbNameFile := fileDownloader.thumbNailFilePrefix + rawFileName
-			fileDownloader.convertImage(outFile, dir+thumbNameFile)
+			fileDownloader.convertImage(ctx, outFile, dir+thumbNameFile)
 		}
+		span.End(nil)
 		fileDownloader.wg.Done()
 	}
 }
 
-func (fileDownloader *fileDownloader) downloadFile(source string, dest string) error {
-	out, err := os.Create(dest)
+func (fileDownloader *fileDownloader) downloadFile(ctx context.Context, source string, dest string) (err error) {
+	ctx, span := startSpan(ctx, fileDownloader.tracer, "vebra download", AttributeURL.String(redactURL(source)))
+	defer func() { span.End(err) }()
+	resp, err := fileDownloader.get(ctx, source)
 	if err != nil {
+		fileDownloader.metrics.observeDownload(0, err)
 		return err
 	}
-	defer out.Close()
-	resp, err := http.Get(source)
+	defer resp.Body.Close()
+	out, err := os.Create(dest)
 	if err != nil {
+		fileDownloader.metrics.observeDownload(0, err)
 		return err
 	}
-	defer resp.Body.Close()
-	_, err = io.Copy(out, resp.Body)
+	defer out.Close()
+	written, err := io.Copy(out, resp.Body)
+	fileDownloader.metrics.observeDownload(written, err)
+	span.SetAttributes(AttributeBytes.Int64(written))
 	return err
 }
 
-func (fileDownloader *fileDownloader) convertImage(source string, dest string) error {
+// get fetches source, retrying according to the downloader's RetryPolicy
+func (fileDownloader *fileDownloader) get(ctx context.Context, source string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			// a request that cannot be built never succeeds, so it is not retried
+			return nil, err
+		}
+		resp, err := http.DefaultClient.Do(request)
+		if err != nil {
+			err = &TransportError{URL: source, Err: err}
+		} else if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		if !fileDownloader.retryPolicy.shouldRetry(attempt, resp, err) {
+			if err != nil {
+				return nil, err
+			}
+			return nil, newAPIError(resp)
+		}
+		event := RetryEvent{
+			Attempt: attempt,
+			URL:     source,
+			Err:     err,
+			Delay:   fileDownloader.retryPolicy.delay(attempt, resp),
+		}
+		if resp != nil {
+			event.StatusCode = resp.StatusCode
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err = fileDownloader.retryPolicy.wait(ctx, event); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (fileDownloader *fileDownloader) convertImage(ctx context.Context, source string, dest string) (err error) {
+	_, span := startSpan(ctx, fileDownloader.tracer, "vebra resize")
+	defer func(started time.Time) {
+		fileDownloader.metrics.observeThumbnail(time.Since(started))
+		span.End(err)
+	}(time.Now())
 	file, err := os.Open(source)
 	if err != nil {
 		return err
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"time"
+)
+
+// Logger receives structured events from an Api. A *slog.Logger satisfies it.
+// Tokens, passwords and Authorization headers are never passed to the Logger.
+type Logger interface {
+	LogAttrs(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr)
+}
+
+// Messages of the events passed to a Logger
+const (
+	LogRequestStarted   = "vebra request started"
+	LogRequestFinished  = "vebra request finished"
+	LogRequestRetrying  = "vebra request retrying"
+	LogTokenAcquired    = "vebra token acquired"
+	LogTokenInvalidated = "vebra token invalidated"
+	LogTokenSaveFailed  = "vebra token save failed"
+	LogTokenDiscarded   = "vebra stored token discarded"
+	LogUnmarshalFailed  = "vebra response unmarshal failed"
+	LogSyncSkipped      = "vebra sync property skipped"
+)
+
+// WithLogger sends structured events about requests, retries, tokens,
+// decoding failures and properties skipped by a Syncer to logger
+func WithLogger(logger Logger) Option {
+	return func(api *Api) {
+		api.logger = logger
+	}
+}
+
+func (api *Api) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if api.logger != nil {
+		api.logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+}
+
+func (api *Api) logRequestStarted(ctx context.Context, requestor *requestor) {
+	api.log(ctx, slog.LevelDebug, LogRequestStarted,
+		slog.String("url", redactURL(requestor.request.URL.String())),
+		slog.String("auth", requestor.authMethod()),
+		slog.Int("attempt", requestor.attempts))
+}
+
+func (api *Api) logRequestFinished(ctx context.Context, requestor *requestor, started time.Time, err error) {
+	attrs := []slog.Attr{
+		slog.String("url", redactURL(requestor.request.URL.String())),
+		slog.String("auth", requestor.authMethod()),
+		slog.Int("attempt", requestor.attempts),
+		slog.Duration("latency", time.Since(started)),
+	}
+	level := slog.LevelInfo
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", err.Error()))
+	} else {
+		attrs = append(attrs, slog.Int("status", requestor.response.StatusCode))
+		if requestor.response.StatusCode >= 400 {
+			level = slog.LevelWarn
+		}
+	}
+	api.log(ctx, level, LogRequestFinished, attrs...)
+}
+
+func (api *Api) logRetry(ctx context.Context, event RetryEvent) {
+	attrs := []slog.Attr{
+		slog.String("url", redactURL(event.URL)),
+		slog.Int("attempt", event.Attempt),
+		slog.Duration("delay", event.Delay),
+	}
+	if event.StatusCode != 0 {
+		attrs = append(attrs, slog.Int("status", event.StatusCode))
+	}
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+	api.log(ctx, slog.LevelWarn, LogRequestRetrying, attrs...)
+}
+
+// logTokenAcquired reports when a new token expires, never the token itself
+func (api *Api) logTokenAcquired(ctx context.Context, token *Token) {
+	api.log(ctx, slog.LevelInfo, LogTokenAcquired, slog.Time("expires_at", token.ExpiresAt()))
+}
+
+func (api *Api) logTokenInvalidated(ctx context.Context, token *Token) {
+	api.log(ctx, slog.LevelWarn, LogTokenInvalidated,
+		slog.Time("acquired_at", token.AcquiredAt()),
+		slog.Duration("age", token.Age()))
+}
+
+func (api *Api) logTokenSaveFailed(ctx context.Context, err error) {
+	api.log(ctx, slog.LevelError, LogTokenSaveFailed, slog.String("error", err.Error()))
+}
+
+func (api *Api) logTokenDiscarded(ctx context.Context, err error) {
+	api.log(ctx, slog.LevelWarn, LogTokenDiscarded, slog.String("error", err.Error()))
+}
+
+func (api *Api) logUnmarshalFailed(ctx context.Context, requestor *requestor, err error) {
+	api.log(ctx, slog.LevelError, LogUnmarshalFailed,
+		slog.String("url", redactURL(requestor.urlBuilder.Build())),
+		slog.String("error", err.Error()))
+}
+
+func (api *Api) logSyncSkipped(ctx context.Context, outcome SyncOutcome) {
+	api.log(ctx, slog.LevelWarn, LogSyncSkipped,
+		slog.Uint64("property_id", uint64(outcome.PropertyID)),
+		slog.String("action", string(outcome.Action)),
+		slog.String("error", outcome.Err.Error()))
+}
+
+// redactURL drops any password embedded in the URL
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return parsed.Redacted()
+}
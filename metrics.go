@@ -0,0 +1,234 @@
+package api
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// requestDurationBuckets are the upper bounds, in seconds, of the request duration histogram
+var requestDurationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+// thumbnailDurationBuckets are the upper bounds, in seconds, of the thumbnail generation histogram
+var thumbnailDurationBuckets = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// Metrics counts the requests of Api clients and the work of file downloaders
+// sharing it. Snapshot reads it, and the prometheus subpackage exports it to
+// Prometheus. The zero value is ready to use, and a nil *Metrics records nothing.
+type Metrics struct {
+	mutex              sync.Mutex
+	requests           map[RequestMetricKey]uint64
+	requestDurations   map[string]*histogram
+	tokenRenewals      uint64
+	unauthorized       uint64
+	bytesDownloaded    uint64
+	downloadFailures   uint64
+	thumbnailDurations *histogram
+	queues             []func() int
+}
+
+// RequestMetricKey identifies a counter of API requests
+type RequestMetricKey struct {
+	Endpoint string
+	Status   string
+}
+
+// MetricsSnapshot is a copy of the values recorded by Metrics
+// Contains:
+// Requests: API requests by endpoint and status
+// RequestDurations: Duration histograms of API requests by endpoint
+// TokenRenewals: Tokens handed out by the API
+// Unauthorized: 401 responses
+// BytesDownloaded: Bytes of media files downloaded
+// DownloadFailures: Media files that could not be downloaded
+// ThumbnailDurations: Duration histogram of thumbnail generation
+// QueueDepth: Properties waiting for their media to be downloaded
+type MetricsSnapshot struct {
+	Requests           map[RequestMetricKey]uint64
+	RequestDurations   map[string]HistogramSnapshot
+	TokenRenewals      uint64
+	Unauthorized       uint64
+	BytesDownloaded    uint64
+	DownloadFailures   uint64
+	ThumbnailDurations HistogramSnapshot
+	QueueDepth         int
+}
+
+// HistogramSnapshot holds the observations of a histogram
+// Contains:
+// Count: The number of observations
+// Sum: The sum of the observations in seconds
+// Buckets: The number of observations at or below each upper bound in seconds
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     float64
+	Buckets map[float64]uint64
+}
+
+// NewMetrics returns an empty Metrics, see WithMetrics and fileDownloader.SetMetrics
+func NewMetrics() *Metrics {
+	return new(Metrics)
+}
+
+// init makes the zero value usable, the mutex must be held
+func (metrics *Metrics) init() {
+	if metrics.requests == nil {
+		metrics.requests = make(map[RequestMetricKey]uint64)
+		metrics.requestDurations = make(map[string]*histogram)
+		metrics.thumbnailDurations = newHistogram(thumbnailDurationBuckets)
+	}
+}
+
+// WithMetrics records the Api's requests and token renewals in metrics
+func WithMetrics(metrics *Metrics) Option {
+	return func(api *Api) {
+		api.metrics = metrics
+	}
+}
+
+func (metrics *Metrics) observeRequest(rawURL string, statusCode int, duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	endpoint := endpointLabel(rawURL)
+	status := "error"
+	if statusCode != 0 {
+		status = strconv.Itoa(statusCode)
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.init()
+	metrics.requests[RequestMetricKey{endpoint, status}]++
+	durations, ok := metrics.requestDurations[endpoint]
+	if !ok {
+		durations = newHistogram(requestDurationBuckets)
+		metrics.requestDurations[endpoint] = durations
+	}
+	durations.observe(duration)
+	if statusCode == http.StatusUnauthorized {
+		metrics.unauthorized++
+	}
+}
+
+func (metrics *Metrics) observeTokenRenewal() {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.tokenRenewals++
+}
+
+func (metrics *Metrics) observeDownload(bytes int64, err error) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.bytesDownloaded += uint64(bytes)
+	if err != nil {
+		metrics.downloadFailures++
+	}
+}
+
+func (metrics *Metrics) observeThumbnail(duration time.Duration) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.init()
+	metrics.thumbnailDurations.observe(duration)
+}
+
+// addQueue makes depth part of the reported queue depth
+func (metrics *Metrics) addQueue(depth func() int) {
+	if metrics == nil {
+		return
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.queues = append(metrics.queues, depth)
+}
+
+// Snapshot returns the current values
+func (metrics *Metrics) Snapshot() MetricsSnapshot {
+	if metrics == nil {
+		return MetricsSnapshot{}
+	}
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.init()
+	snapshot := MetricsSnapshot{
+		Requests:           make(map[RequestMetricKey]uint64, len(metrics.requests)),
+		RequestDurations:   make(map[string]HistogramSnapshot, len(metrics.requestDurations)),
+		TokenRenewals:      metrics.tokenRenewals,
+		Unauthorized:       metrics.unauthorized,
+		BytesDownloaded:    metrics.bytesDownloaded,
+		DownloadFailures:   metrics.downloadFailures,
+		ThumbnailDurations: metrics.thumbnailDurations.snapshot(),
+	}
+	for key, count := range metrics.requests {
+		snapshot.Requests[key] = count
+	}
+	for endpoint, durations := range metrics.requestDurations {
+		snapshot.RequestDurations[endpoint] = durations.snapshot()
+	}
+	for _, depth := range metrics.queues {
+		snapshot.QueueDepth += depth()
+	}
+	return snapshot
+}
+
+// endpointLabel names the endpoint of an API URL by its non-numeric path
+// segments, so IDs and dates do not create a label value per request,
+// e.g. branch/property for export/{datafeedid}/v10/branch/3741/property/26858499
+func endpointLabel(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "unknown"
+	}
+	var segments []string
+	for _, segment := range strings.Split(recordedPathPattern.ReplaceAllString(parsed.Path, ""), "/") {
+		if _, err := strconv.Atoi(segment); segment != "" && err != nil {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return "root"
+	}
+	return strings.Join(segments, "/")
+}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(duration time.Duration) {
+	seconds := duration.Seconds()
+	h.count++
+	h.sum += seconds
+	for i := sort.SearchFloat64s(h.bounds, seconds); i < len(h.bounds); i++ {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{Count: h.count, Sum: h.sum, Buckets: make(map[float64]uint64, len(h.bounds))}
+	for i, bound := range h.bounds {
+		snapshot.Buckets[bound] = h.counts[i]
+	}
+	return snapshot
+}
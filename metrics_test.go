@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEndpointLabel(t *testing.T) {
+	labels := map[string]string{
+		"http://webservices.vebra.com/export/ABCDEFG/v10/branch":                        "branch",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741":                   "branch",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741/property":          "branch/property",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741/property/26858499": "branch/property",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/property/2019/01/02/03/04/05":  "property",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/files/2019/01/02/03/04/05":     "files",
+		"http://webservices.vebra.com/export/ABCDEFG/v10/":                              "root",
+	}
+	for url, expected := range labels {
+		if label := endpointLabel(url); label != expected {
+			t.Errorf("Expected [%s] for [%s] but found [%s]", expected, url, label)
+		}
+	}
+}
+
+func TestApiRecordsMetrics(t *testing.T) {
+	stub := newStubVebra(`<branches></branches>`)
+	defer stub.Close()
+
+	metrics := NewMetrics()
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithMetrics(metrics))
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	stub.expireToken()
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := metrics.Snapshot()
+	if count := snapshot.Requests[RequestMetricKey{"branch", "200"}]; count != 2 {
+		t.Errorf("Expected [2] successful branch requests but found [%d]", count)
+	}
+	if count := snapshot.Requests[RequestMetricKey{"branch", "401"}]; count != 1 {
+		t.Errorf("Expected [1] rejected branch request but found [%d]", count)
+	}
+	if snapshot.TokenRenewals != 2 || snapshot.Unauthorized != 1 {
+		t.Errorf("Expected [2] renewals and [1] 401 but found [%d] and [%d]", snapshot.TokenRenewals, snapshot.Unauthorized)
+	}
+	if durations := snapshot.RequestDurations["branch"]; durations.Count != 3 {
+		t.Errorf("Expected [3] observed durations but found [%d]", durations.Count)
+	}
+}
+
+func TestFileDownloaderRecordsMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing.png" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 20, 20)))
+	}))
+	defer server.Close()
+
+	metrics := NewMetrics()
+	downloader := &fileDownloader{retryPolicy: NoRetryPolicy(), maxHeight: 100, propertyChan: make(chan *propertyFilesManifest, 2)}
+	downloader.SetMetrics(metrics)
+	downloader.propertyChan <- &propertyFilesManifest{}
+
+	directory := t.TempDir()
+	dest := filepath.Join(directory, "image.png")
+	if err := downloader.downloadFile(context.Background(), server.URL+"/image.png", dest); err != nil {
+		t.Fatal(err)
+	}
+	downloader.downloadFile(context.Background(), server.URL+"/missing.png", filepath.Join(directory, "missing.png"))
+	if err := downloader.convertImage(context.Background(), dest, filepath.Join(directory, "tn_image.png")); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := metrics.Snapshot()
+	if snapshot.BytesDownloaded != uint64(info.Size()) || snapshot.DownloadFailures != 1 {
+		t.Errorf("Expected [%d] bytes and [1] failure but found [%d] and [%d]", info.Size(), snapshot.BytesDownloaded, snapshot.DownloadFailures)
+	}
+	if snapshot.ThumbnailDurations.Count != 1 {
+		t.Errorf("Expected [1] thumbnail but found [%d]", snapshot.ThumbnailDurations.Count)
+	}
+	if snapshot.QueueDepth != 1 {
+		t.Errorf("Expected queue depth [1] but found [%d]", snapshot.QueueDepth)
+	}
+}
+
+func TestZeroMetricsRecords(t *testing.T) {
+	for _, metrics := range []*Metrics{new(Metrics), {}} {
+		metrics.observeRequest("http://webservices.vebra.com/export/ABCDEFG/v10/branch", http.StatusOK, time.Second)
+		metrics.observeThumbnail(time.Second)
+		snapshot := metrics.Snapshot()
+		if snapshot.Requests[RequestMetricKey{"branch", "200"}] != 1 || snapshot.ThumbnailDurations.Count != 1 {
+			t.Errorf("Expected [1] request and [1] thumbnail but found [%+v]", snapshot)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"net/http"
+	"time"
+)
+
+// Option configures an Api created by NewApi
+type Option func(api *Api)
+
+// WithHTTPClient sets the http.Client used for every request.
+// Sharing one client lets keep-alive connections be reused across calls.
+// A nil client keeps the default one.
+func WithHTTPClient(client *http.Client) Option {
+	return func(api *Api) {
+		if client != nil {
+			api.httpClient = client
+		}
+	}
+}
+
+// WithTransport makes the Api's http.Client send requests through transport,
+// e.g. NewRemoteFileGetterLocalWriter or NewLocalFileGetter. It applies to the
+// client given by WithHTTPClient whatever the order of the options, without
+// changing that client.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(api *Api) {
+		api.transport = transport
+	}
+}
+
+// WithBaseURL points the Api at baseURL instead of BaseURL,
+// e.g. an HTTPS endpoint, a proxy or a local stub server
+func WithBaseURL(baseURL string) Option {
+	return func(api *Api) {
+		api.baseURL = baseURL
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(userAgent string) Option {
+	return func(api *Api) {
+		api.userAgent = userAgent
+	}
+}
+
+// WithTimeout bounds the time spent on a single Api call, including all of its
+// retries. A zero timeout means no limit.
+func WithTimeout(timeout time.Duration) Option {
+	return func(api *Api) {
+		api.timeout = timeout
+	}
+}
+
+// WithRetryPolicy replaces DefaultRetryPolicy for every Api call
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(api *Api) {
+		api.retryPolicy = policy
+	}
+}
+
+// WithRateLimit throttles the requests made by the Api, see RateLimit
+func WithRateLimit(rateLimit RateLimit) Option {
+	return func(api *Api) {
+		api.limiter = newRateLimiter(rateLimit.RequestsPerSecond, rateLimit.Burst, rateLimit.Mode)
+		api.tokenLimiter = newRateLimiter(rateLimit.TokenRequestsPerSecond, rateLimit.TokenBurst, rateLimit.Mode)
+	}
+}
+
+// WithResponseCache keeps successful responses in cache. Cached responses are
+// served without a request for ttl after they were stored, and afterwards
+// revalidated with a conditional request, decoding the cached body when the API
+// answers 304 Not Modified. A zero ttl revalidates on every call.
+func WithResponseCache(cache ResponseCache, ttl time.Duration) Option {
+	return func(api *Api) {
+		api.responseCache = cache
+		api.responseCacheTTL = ttl
+	}
+}
+
+// WithTokenRenewBefore renews the token once it expires within renewBefore instead
+// of DefaultTokenRenewBefore. Requests keep using the current token meanwhile, and
+// if the API refuses to hand out a new one early it is used until it expires.
+// A zero duration only renews expired or rejected tokens.
+func WithTokenRenewBefore(renewBefore time.Duration) Option {
+	return func(api *Api) {
+		api.tokens.renewBefore = renewBefore
+	}
+}
@@ -0,0 +1,69 @@
+// Package otel traces Api calls and media downloads with OpenTelemetry, keeping
+// OpenTelemetry out of the api package
+package otel
+
+import (
+	"context"
+	"fmt"
+
+	api "github.com/joesteel2010/vebra-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation scope of the spans created for the api package
+const tracerName = "github.com/joesteel2010/vebra-api"
+
+// Tracer is an api.Tracer creating OpenTelemetry spans
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a Tracer creating spans with provider, to be passed to
+// api.WithTracer and a file downloader's SetTracer
+func NewTracer(provider trace.TracerProvider) *Tracer {
+	return &Tracer{tracer: provider.Tracer(tracerName)}
+}
+
+// Start implements api.Tracer
+func (tracer *Tracer) Start(ctx context.Context, name string, attributes ...api.Attribute) (context.Context, api.Span) {
+	ctx, otelSpan := tracer.tracer.Start(ctx, name, trace.WithAttributes(keyValues(attributes)...))
+	return ctx, span{otelSpan}
+}
+
+// span adapts an OpenTelemetry span to api.Span
+type span struct {
+	span trace.Span
+}
+
+func (span span) SetAttributes(attributes ...api.Attribute) {
+	span.span.SetAttributes(keyValues(attributes)...)
+}
+
+func (span span) End(err error) {
+	if err != nil {
+		span.span.RecordError(err)
+		span.span.SetStatus(codes.Error, err.Error())
+	}
+	span.span.End()
+}
+
+// keyValues converts attributes to OpenTelemetry ones
+func keyValues(attributes []api.Attribute) []attribute.KeyValue {
+	keyValues := make([]attribute.KeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		key := string(a.Key)
+		switch value := a.Value.(type) {
+		case string:
+			keyValues = append(keyValues, attribute.String(key, value))
+		case int64:
+			keyValues = append(keyValues, attribute.Int64(key, value))
+		case bool:
+			keyValues = append(keyValues, attribute.Bool(key, value))
+		default:
+			keyValues = append(keyValues, attribute.String(key, fmt.Sprint(value)))
+		}
+	}
+	return keyValues
+}
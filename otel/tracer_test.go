@@ -0,0 +1,73 @@
+package otel
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/joesteel2010/vebra-api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracerCreatesSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider)
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "sync")
+	_, span := tracer.Start(ctx, "vebra branch", api.AttributePropertyID.Int(26858499), api.AttributeFromCache.Bool(true))
+	span.SetAttributes(api.AttributeURL.String("http://example.com"))
+	span.End(errors.New("failed"))
+	parent.End()
+
+	ended := recorder.Ended()
+	if len(ended) != 2 || ended[0].Name() != "vebra branch" {
+		t.Fatalf("Expected the span [vebra branch] to be ended first but found [%d] spans", len(ended))
+	}
+	if ended[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected the span to be a child of [%s] but found [%s]", parent.SpanContext().SpanID(), ended[0].Parent().SpanID())
+	}
+	expected := []attribute.KeyValue{
+		attribute.Int64("vebra.property_id", 26858499),
+		attribute.Bool("vebra.from_cache", true),
+		attribute.String("url.full", "http://example.com"),
+	}
+	for i, kv := range ended[0].Attributes() {
+		if i >= len(expected) || kv != expected[i] {
+			t.Errorf("Expected attributes [%v] but found [%v]", expected, ended[0].Attributes())
+			break
+		}
+	}
+	if status := ended[0].Status(); status.Code != codes.Error || status.Description != "failed" {
+		t.Errorf("Expected an error status but found [%+v]", status)
+	}
+}
+
+func TestTracerTracesApiCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(api.HeaderTokenKey, "token")
+		w.Write([]byte(`<branches></branches>`))
+	}))
+	defer server.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	client := api.NewApi("ABCDEFG", "user", "password", api.WithBaseURL(server.URL), api.WithTracer(NewTracer(provider)))
+	if _, err := client.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, span := range recorder.Ended() {
+		names[span.Name()] = true
+	}
+	for _, name := range []string{"vebra branch", "vebra unmarshal"} {
+		if !names[name] {
+			t.Errorf("Expected a span [%s] but found [%v]", name, names)
+		}
+	}
+}
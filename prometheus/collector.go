@@ -0,0 +1,65 @@
+// Package prometheus exports the Metrics of Api clients and file downloaders to
+// Prometheus, keeping the Prometheus client out of the api package
+package prometheus
+
+import (
+	api "github.com/joesteel2010/vebra-api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	requestsDesc = prometheus.NewDesc("vebra_api_requests_total",
+		"API requests by endpoint and status code, or \"error\" when no response was received.",
+		[]string{"endpoint", "status"}, nil)
+	requestDurationDesc = prometheus.NewDesc("vebra_api_request_duration_seconds",
+		"Duration of API requests by endpoint.",
+		[]string{"endpoint"}, nil)
+	tokenRenewalsDesc = prometheus.NewDesc("vebra_token_renewals_total",
+		"Tokens handed out by the API.", nil, nil)
+	unauthorizedDesc = prometheus.NewDesc("vebra_api_unauthorized_total",
+		"API responses with status 401 Unauthorized.", nil, nil)
+	bytesDownloadedDesc = prometheus.NewDesc("vebra_media_downloaded_bytes_total",
+		"Bytes of media files downloaded.", nil, nil)
+	downloadFailuresDesc = prometheus.NewDesc("vebra_media_download_failures_total",
+		"Media files that could not be downloaded.", nil, nil)
+	thumbnailDurationDesc = prometheus.NewDesc("vebra_thumbnail_duration_seconds",
+		"Time spent generating thumbnails.", nil, nil)
+	queueDepthDesc = prometheus.NewDesc("vebra_download_queue_depth",
+		"Properties waiting for their media to be downloaded.", nil, nil)
+)
+
+// Collector is a prometheus.Collector reporting a snapshot of api.Metrics on every scrape
+type Collector struct {
+	metrics *api.Metrics
+}
+
+// NewCollector returns a Collector for metrics, to be registered with a prometheus.Registerer
+func NewCollector(metrics *api.Metrics) *Collector {
+	return &Collector{metrics: metrics}
+}
+
+// Describe implements prometheus.Collector
+func (collector *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{requestsDesc, requestDurationDesc, tokenRenewalsDesc, unauthorizedDesc,
+		bytesDownloadedDesc, downloadFailuresDesc, thumbnailDurationDesc, queueDepthDesc} {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector
+func (collector *Collector) Collect(ch chan<- prometheus.Metric) {
+	snapshot := collector.metrics.Snapshot()
+	for key, count := range snapshot.Requests {
+		ch <- prometheus.MustNewConstMetric(requestsDesc, prometheus.CounterValue, float64(count), key.Endpoint, key.Status)
+	}
+	for endpoint, durations := range snapshot.RequestDurations {
+		ch <- prometheus.MustNewConstHistogram(requestDurationDesc, durations.Count, durations.Sum, durations.Buckets, endpoint)
+	}
+	ch <- prometheus.MustNewConstMetric(tokenRenewalsDesc, prometheus.CounterValue, float64(snapshot.TokenRenewals))
+	ch <- prometheus.MustNewConstMetric(unauthorizedDesc, prometheus.CounterValue, float64(snapshot.Unauthorized))
+	ch <- prometheus.MustNewConstMetric(bytesDownloadedDesc, prometheus.CounterValue, float64(snapshot.BytesDownloaded))
+	ch <- prometheus.MustNewConstMetric(downloadFailuresDesc, prometheus.CounterValue, float64(snapshot.DownloadFailures))
+	ch <- prometheus.MustNewConstHistogram(thumbnailDurationDesc, snapshot.ThumbnailDurations.Count,
+		snapshot.ThumbnailDurations.Sum, snapshot.ThumbnailDurations.Buckets)
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(snapshot.QueueDepth))
+}
@@ -0,0 +1,42 @@
+package prometheus
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	api "github.com/joesteel2010/vebra-api"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollectorGathersMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(api.HeaderTokenKey, "token")
+		w.Write([]byte(`<branches></branches>`))
+	}))
+	defer server.Close()
+
+	metrics := api.NewMetrics()
+	client := api.NewApi("ABCDEFG", "user", "password", api.WithBaseURL(server.URL), api.WithMetrics(metrics))
+	if _, err := client.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := prometheus.NewRegistry()
+	if err := registry.Register(NewCollector(metrics)); err != nil {
+		t.Fatal(err)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{"vebra_api_requests_total", "vebra_api_request_duration_seconds", "vebra_token_renewals_total", "vebra_download_queue_depth"} {
+		if !names[name] {
+			t.Errorf("Expected metric [%s] to be gathered", name)
+		}
+	}
+}
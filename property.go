@@ -27,20 +27,41 @@ type URLBuilder interface {
 }
 
 type ChangedPropertyURLBuilder struct {
-	url string
+	url     string
+	baseURL string
 }
 
 func (builder *ChangedPropertyURLBuilder) SetURL(url string) {
 	builder.url = url
 }
 
+// SetBaseURL moves URLs pointing at the default BaseURL onto baseURL
+func (builder *ChangedPropertyURLBuilder) SetBaseURL(baseURL string) {
+	builder.baseURL = baseURL
+}
+
 func (builder *ChangedPropertyURLBuilder) Build() string {
-	return builder.url
+	return rebaseURL(builder.url, builder.baseURL)
 }
 
 type vebraURLBuilder struct {
 	url        string
 	dataFeedID string
+	baseURL    string
+}
+
+// template returns the URL template rooted at the builder's base URL
+func (b *vebraURLBuilder) template(template string) string {
+	return rebaseURL(template, b.baseURL)
+}
+
+// rebaseURL replaces the default BaseURL prefix of url with baseURL.
+// URLs not starting with BaseURL, or an empty baseURL, leave url untouched.
+func rebaseURL(url string, baseURL string) string {
+	if baseURL == "" || !strings.HasPrefix(url, BaseURL) {
+		return url
+	}
+	return strings.TrimSuffix(baseURL, "/") + "/" + strings.TrimPrefix(url, BaseURL)
 }
 
 type URLGetBranchesBuilder struct {
@@ -52,8 +73,13 @@ func (b *URLGetBranchesBuilder) SetDataFeedID(dataFeedID string) *URLGetBranches
 	return b
 }
 
+func (b *URLGetBranchesBuilder) SetBaseURL(baseURL string) *URLGetBranchesBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetBranchesBuilder) Build() string {
-	b.url = URLGetBranches
+	b.url = b.template(URLGetBranches)
 	return strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 }
 
@@ -67,13 +93,18 @@ func (b *URLGetBranchBuilder) SetDataFeedID(dataFeedID string) *URLGetBranchBuil
 	return b
 }
 
+func (b *URLGetBranchBuilder) SetBaseURL(baseURL string) *URLGetBranchBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetBranchBuilder) SetClientID(clientID string) *URLGetBranchBuilder {
 	b.clientID = clientID
 	return b
 }
 
 func (b *URLGetBranchBuilder) Build() string {
-	b.url = URLGetBranch
+	b.url = b.template(URLGetBranch)
 	b.url = strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 	b.url = strings.Replace(b.url, "{clientid}", b.clientID, 1)
 	return b.url
@@ -89,13 +120,18 @@ func (b *URLGetChangedFilesBuilder) SetDataFeedID(dataFeedID string) *URLGetChan
 	return b
 }
 
+func (b *URLGetChangedFilesBuilder) SetBaseURL(baseURL string) *URLGetChangedFilesBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetChangedFilesBuilder) SetSince(time time.Time) *URLGetChangedFilesBuilder {
 	b.since = time
 	return b
 }
 
 func (b *URLGetChangedFilesBuilder) Build() string {
-	b.url = URLGetChangedFiles
+	b.url = b.template(URLGetChangedFiles)
 	b.url = strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 	b.url = strings.Replace(b.url, "{yyyy}", fmt.Sprintf("%04d", b.since.Year()), 1)
 	b.url = strings.Replace(b.url, "{MM}", fmt.Sprintf("%02d", int(b.since.Month())), 1)
@@ -116,13 +152,18 @@ func (b *URLGetPropertiesBuilder) SetDataFeedID(dataFeedID string) *URLGetProper
 	return b
 }
 
+func (b *URLGetPropertiesBuilder) SetBaseURL(baseURL string) *URLGetPropertiesBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetPropertiesBuilder) SetClientID(clientID string) *URLGetPropertiesBuilder {
 	b.clientID = clientID
 	return b
 }
 
 func (b *URLGetPropertiesBuilder) Build() string {
-	b.url = URLGetProperties
+	b.url = b.template(URLGetProperties)
 	b.url = strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 	b.url = strings.Replace(b.url, "{clientid}", b.clientID, 1)
 	return b.url
@@ -138,6 +179,11 @@ func (b *URLGetPropertyBuilder) SetDataFeedID(dataFeedID string) *URLGetProperty
 	return b
 }
 
+func (b *URLGetPropertyBuilder) SetBaseURL(baseURL string) *URLGetPropertyBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetPropertyBuilder) SetClientID(clientID string) *URLGetPropertyBuilder {
 	b.clientID = clientID
 	return b
@@ -149,7 +195,7 @@ func (b *URLGetPropertyBuilder) SetPropertyID(propertyID string) *URLGetProperty
 }
 
 func (b *URLGetPropertyBuilder) Build() string {
-	b.url = URLGetProperty
+	b.url = b.template(URLGetProperty)
 	b.url = strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 	b.url = strings.Replace(b.url, "{clientid}", b.clientID, 1)
 	b.url = strings.Replace(b.url, "{prop_id}", b.propertyID, 1)
@@ -166,13 +212,18 @@ func (b *URLGetChangedPropertiesBuilder) SetDataFeedID(dataFeedID string) *URLGe
 	return b
 }
 
+func (b *URLGetChangedPropertiesBuilder) SetBaseURL(baseURL string) *URLGetChangedPropertiesBuilder {
+	b.baseURL = baseURL
+	return b
+}
+
 func (b *URLGetChangedPropertiesBuilder) SetSince(time time.Time) *URLGetChangedPropertiesBuilder {
 	b.since = time
 	return b
 }
 
 func (b *URLGetChangedPropertiesBuilder) Build() string {
-	b.url = URLGetChangedProperties
+	b.url = b.template(URLGetChangedProperties)
 	b.url = strings.Replace(b.url, "{datafeedid}", b.dataFeedID, 1)
 	b.url = strings.Replace(b.url, "{yyyy}", fmt.Sprintf("%04d", b.since.Year()), 1)
 	b.url = strings.Replace(b.url, "{MM}", fmt.Sprintf("%02d", int(b.since.Month())), 1)
@@ -86,6 +86,30 @@ func TestURLGetChangedProperties(t *testing.T) {
 	}
 }
 
+func TestURLGetPropertyBuilderBaseURL(t *testing.T) {
+	const expected = "https://localhost:8080/export/ABCDEFG/v10/branch/1234567/property/7654321"
+
+	propertyBuilder := new(URLGetPropertyBuilder)
+	url := propertyBuilder.SetBaseURL("https://localhost:8080").SetDataFeedID("ABCDEFG").SetClientID("1234567").SetPropertyID("7654321").Build()
+
+	if url != expected {
+		t.Errorf("Expected [%s] but found [%s]", expected, url)
+	}
+}
+
+func TestChangedPropertyURLBuilderBaseURL(t *testing.T) {
+	const expected = "https://localhost:8080/export/ABCDEFG/v10/branch/1234567/property/7654321"
+
+	changedPropertyBuilder := new(ChangedPropertyURLBuilder)
+	changedPropertyBuilder.SetBaseURL("https://localhost:8080/")
+	changedPropertyBuilder.SetURL("http://webservices.vebra.com/export/ABCDEFG/v10/branch/1234567/property/7654321")
+	url := changedPropertyBuilder.Build()
+
+	if url != expected {
+		t.Errorf("Expected [%s] but found [%s]", expected, url)
+	}
+}
+
 func ReadPropertiesHelper(t *testing.T) []Property {
 	files, err := ioutil.ReadDir("test_assets/api/branch/3741/property/")
 	if err != nil {
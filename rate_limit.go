@@ -0,0 +1,111 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimitMode decides what happens to a request that exceeds the rate limit
+type RateLimitMode int
+
+const (
+	// RateLimitBlock makes the request wait until the budget allows it
+	RateLimitBlock RateLimitMode = iota
+	// RateLimitFail makes the request fail with ErrRateLimited
+	RateLimitFail
+)
+
+// RateLimit configures the client-side rate limiting of an Api.
+// Contains:
+// RequestsPerSecond: Sustained rate of requests of any kind. Zero disables the limit.
+// Burst: Number of requests allowed at once before the rate applies. At least 1.
+// TokenRequestsPerSecond: Sustained rate of basic auth requests acquiring a new token. Zero disables the limit.
+// TokenBurst: Number of basic auth requests allowed at once. At least 1.
+// Mode: Whether requests over the limit wait or fail.
+type RateLimit struct {
+	RequestsPerSecond      float64
+	Burst                  int
+	TokenRequestsPerSecond float64
+	TokenBurst             int
+	Mode                   RateLimitMode
+}
+
+// rateLimiter is a token bucket refilled at rate tokens per second holding at most burst tokens
+type rateLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	mode   RateLimitMode
+}
+
+// newRateLimiter returns nil, meaning unlimited, when rate is not positive
+func newRateLimiter(rate float64, burst int, mode RateLimitMode) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+		mode:   mode,
+	}
+}
+
+// wait takes one token from the bucket, waiting for it or failing with
+// ErrRateLimited depending on the limiter's mode. A nil limiter never limits.
+func (limiter *rateLimiter) wait(ctx context.Context) error {
+	if limiter == nil {
+		return nil
+	}
+	delay, ok := limiter.reserve()
+	if !ok {
+		return ErrRateLimited
+	}
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		limiter.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token and returns how long the caller has to wait before it
+// may be used. In RateLimitFail mode no token is taken unless one is available now.
+func (limiter *rateLimiter) reserve() (time.Duration, bool) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	now := time.Now()
+	limiter.tokens += now.Sub(limiter.last).Seconds() * limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	if limiter.mode == RateLimitFail && limiter.tokens < 1 {
+		return 0, false
+	}
+	limiter.tokens--
+	if limiter.tokens >= 0 {
+		return 0, true
+	}
+	return time.Duration(-limiter.tokens / limiter.rate * float64(time.Second)), true
+}
+
+// cancel hands back a token reserved by a caller that gave up waiting
+func (limiter *rateLimiter) cancel() {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	limiter.tokens++
+}
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterFailMode(t *testing.T) {
+	limiter := newRateLimiter(1, 2, RateLimitFail)
+	for i := 0; i < 2; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatalf("Expected request [%d] within the burst to pass but found [%v]", i, err)
+		}
+	}
+	if err := limiter.wait(context.Background()); err != ErrRateLimited {
+		t.Errorf("Expected [%v] but found [%v]", ErrRateLimited, err)
+	}
+}
+
+func TestRateLimiterBlockMode(t *testing.T) {
+	limiter := newRateLimiter(20, 1, RateLimitBlock)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 3 requests at 20/s with a burst of 1 to take about 100ms but took [%s]", elapsed)
+	}
+}
+
+func TestRateLimiterBlockModeHonoursContext(t *testing.T) {
+	limiter := newRateLimiter(0.1, 1, RateLimitBlock)
+	limiter.wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected [%v] but found [%v]", context.DeadlineExceeded, err)
+	}
+	if limiter.tokens < -0.01 {
+		t.Errorf("Expected the abandoned reservation to be handed back but found [%f] tokens", limiter.tokens)
+	}
+}
+
+func TestNilRateLimiterNeverLimits(t *testing.T) {
+	limiter := newRateLimiter(0, 0, RateLimitFail)
+	for i := 0; i < 100; i++ {
+		if err := limiter.wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestApiRateLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<branches></branches>`))
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password",
+		WithBaseURL(server.URL),
+		WithRateLimit(RateLimit{RequestsPerSecond: 1, Burst: 5, TokenRequestsPerSecond: 1, TokenBurst: 2, Mode: RateLimitFail}),
+	)
+	// no token is handed out, so every request is a basic auth request
+	for i := 0; i < 2; i++ {
+		if _, err := api.GetBranches(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := api.GetBranches(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Expected [%v] but found [%v]", ErrRateLimited, err)
+	}
+}
@@ -0,0 +1,242 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ErrTooManyDeletions is returned when auto-fix would delete more properties than
+// a Reconciler allows, e.g. because the API listed none of them
+var ErrTooManyDeletions = errors.New("auto-fix would delete too many properties")
+
+// SinkRecord describes a property held by a Sink
+// Contains:
+// PropertyID: The property's ID
+// LastChanged: When the API last changed the sink's copy, or else when the copy
+// was upserted. The zero time if unknown.
+type SinkRecord struct {
+	PropertyID  uint
+	LastChanged time.Time
+}
+
+// InventorySink is a Sink that can list the properties it holds, as needed by a Reconciler
+type InventorySink interface {
+	Sink
+	Records() ([]SinkRecord, error)
+}
+
+// LiveProperty is a property listed by the API
+// Contains:
+// Branch: The branch listing the property
+// Summary: The property as listed
+type LiveProperty struct {
+	Branch  BranchSummary
+	Summary PropertySummary
+}
+
+// ReconcileReport lists how the properties held by a Sink differ from the ones listed by the API
+// Contains:
+// Live: The number of properties listed by the API
+// Missing: Properties listed by the API but not held by the sink
+// Stale: Properties the API changed after the sink's copy
+// Extra: IDs of properties held by the sink but no longer listed by the API
+// Outcomes: What auto-fix did, empty unless it is enabled
+type ReconcileReport struct {
+	Live     int
+	Missing  []LiveProperty
+	Stale    []LiveProperty
+	Extra    []uint
+	Outcomes []SyncOutcome
+}
+
+// InSync tells whether the sink held exactly the live properties
+func (report *ReconcileReport) InSync() bool {
+	return len(report.Missing) == 0 && len(report.Stale) == 0 && len(report.Extra) == 0
+}
+
+// Reconciler compares every property listed by the API, branch by branch, with
+// the properties held by a Sink. It finds the withdrawals GetChangedProperties
+// missed, which a Syncer cannot.
+type Reconciler struct {
+	api         *Api
+	sink        InventorySink
+	concurrency int
+	autoFix     bool
+	// maxDeletions and maxDeletionFraction limit the extra properties auto-fix deletes, unlimited if 0
+	maxDeletions        int
+	maxDeletionFraction float64
+}
+
+// NewReconciler returns a Reconciler comparing api's feed with sink
+func NewReconciler(api *Api, sink InventorySink) *Reconciler {
+	return &Reconciler{
+		api:         api,
+		sink:        sink,
+		concurrency: DefaultSyncConcurrency,
+	}
+}
+
+// SetConcurrency sets the number of branches listed, and of properties fixed, at once
+func (reconciler *Reconciler) SetConcurrency(concurrency int) *Reconciler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	reconciler.concurrency = concurrency
+	return reconciler
+}
+
+// SetAutoFix makes Reconcile upsert missing and stale properties and delete extra ones
+func (reconciler *Reconciler) SetAutoFix(autoFix bool) *Reconciler {
+	reconciler.autoFix = autoFix
+	return reconciler
+}
+
+// SetMaxDeletions makes auto-fix refuse to delete more than count extra properties.
+// 0 removes the limit.
+func (reconciler *Reconciler) SetMaxDeletions(count int) *Reconciler {
+	if count < 0 {
+		count = 0
+	}
+	reconciler.maxDeletions = count
+	return reconciler
+}
+
+// SetMaxDeletionFraction makes auto-fix refuse to delete more than fraction of
+// the properties held by the sink, e.g. 0.1 for a tenth. 0 removes the limit.
+func (reconciler *Reconciler) SetMaxDeletionFraction(fraction float64) *Reconciler {
+	if fraction < 0 {
+		fraction = 0
+	}
+	reconciler.maxDeletionFraction = fraction
+	return reconciler
+}
+
+// Reconcile lists the properties of every branch and reports how the sink
+// differs. Nothing is reported if any branch cannot be listed, as its
+// properties would otherwise look extra. With auto-fix, a failure to fix a
+// property is returned as a *SyncError along with the report. Auto-fix changes
+// nothing and returns ErrTooManyDeletions along with the report when the API
+// listed no properties while the sink holds some, or when it would delete more
+// properties than the limits set with SetMaxDeletions and SetMaxDeletionFraction.
+func (reconciler *Reconciler) Reconcile(ctx context.Context) (*ReconcileReport, error) {
+	branches, err := reconciler.api.GetBranchesContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	listed := make([][]PropertySummary, len(branches.Branches))
+	errs := runConcurrently(ctx, reconciler.concurrency, len(branches.Branches), func(ctx context.Context, i int) error {
+		return reconciler.api.IteratePropertiesContext(ctx, &branches.Branches[i], func(summary PropertySummary) error {
+			listed[i] = append(listed[i], summary)
+			return nil
+		})
+	})
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("listing the properties of branch [%s]: %w", branches.Branches[i].GetClientIDString(), err)
+		}
+	}
+	records, err := reconciler.sink.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	held := make(map[uint]SinkRecord, len(records))
+	for _, record := range records {
+		held[record.PropertyID] = record
+	}
+	report := new(ReconcileReport)
+	live := make(map[uint]bool)
+	for i, summaries := range listed {
+		for _, summary := range summaries {
+			if live[summary.PropertyID] {
+				continue
+			}
+			live[summary.PropertyID] = true
+			property := LiveProperty{Branch: branches.Branches[i], Summary: summary}
+			record, ok := held[summary.PropertyID]
+			switch {
+			case !ok:
+				report.Missing = append(report.Missing, property)
+			case isStale(record, summary):
+				report.Stale = append(report.Stale, property)
+			}
+		}
+	}
+	report.Live = len(live)
+	for id := range held {
+		if !live[id] {
+			report.Extra = append(report.Extra, id)
+		}
+	}
+	sort.Slice(report.Extra, func(i, j int) bool { return report.Extra[i] < report.Extra[j] })
+
+	if !reconciler.autoFix || report.InSync() {
+		return report, nil
+	}
+	if err = reconciler.checkDeletions(report, len(held)); err != nil {
+		return report, err
+	}
+	report.Outcomes = reconciler.fix(ctx, report)
+	if batch, ok := reconciler.sink.(BatchSink); ok {
+		if err = batch.Commit(); err != nil {
+			return report, fmt.Errorf("sink did not commit the fixes: %w", err)
+		}
+	}
+	if failed := failedOutcomes(report.Outcomes); len(failed) > 0 {
+		return report, &SyncError{Outcomes: failed}
+	}
+	return report, nil
+}
+
+// checkDeletions returns ErrTooManyDeletions if auto-fix must not delete the
+// extra properties of report out of the held ones
+func (reconciler *Reconciler) checkDeletions(report *ReconcileReport, held int) error {
+	extra := len(report.Extra)
+	switch {
+	case report.Live == 0 && held > 0:
+		return fmt.Errorf("%w: the API listed no properties while the sink holds [%d]", ErrTooManyDeletions, held)
+	case reconciler.maxDeletions > 0 && extra > reconciler.maxDeletions:
+		return fmt.Errorf("%w: [%d] extra properties exceed the limit of [%d]", ErrTooManyDeletions, extra, reconciler.maxDeletions)
+	case reconciler.maxDeletionFraction > 0 && float64(extra) > reconciler.maxDeletionFraction*float64(held):
+		return fmt.Errorf("%w: [%d] extra properties exceed [%g] of the [%d] held", ErrTooManyDeletions, extra, reconciler.maxDeletionFraction, held)
+	}
+	return nil
+}
+
+// fix fetches and upserts the missing and stale properties and deletes the extra ones
+func (reconciler *Reconciler) fix(ctx context.Context, report *ReconcileReport) []SyncOutcome {
+	upserts := append(append([]LiveProperty(nil), report.Missing...), report.Stale...)
+	outcomes := make([]SyncOutcome, 0, len(upserts)+len(report.Extra))
+	for _, property := range upserts {
+		outcomes = append(outcomes, SyncOutcome{PropertyID: property.Summary.PropertyID, Action: SyncUpsert})
+	}
+	for _, id := range report.Extra {
+		outcomes = append(outcomes, SyncOutcome{PropertyID: id, Action: SyncDelete})
+	}
+	errs := runConcurrently(ctx, reconciler.concurrency, len(outcomes), func(ctx context.Context, i int) error {
+		if i >= len(upserts) {
+			return reconciler.sink.Delete(outcomes[i].PropertyID)
+		}
+		property, err := reconciler.api.GetPropertyContext(ctx, &upserts[i].Branch, upserts[i].Summary)
+		if err != nil {
+			return err
+		}
+		return reconciler.sink.Upsert(property)
+	})
+	for i, err := range errs {
+		outcomes[i].Err = err
+	}
+	return outcomes
+}
+
+// isStale tells whether the API changed a property after the sink's copy.
+// A copy without a known change time is never considered stale.
+func isStale(record SinkRecord, summary PropertySummary) bool {
+	if record.LastChanged.IsZero() || summary.LastChanged == nil || summary.LastChanged.Datetime == nil {
+		return false
+	}
+	return summary.LastChanged.Datetime.After(record.LastChanged)
+}
@@ -0,0 +1,163 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newReconcileStub serves two branches, 1001 listing properties 1 and 2 and
+// 1002 listing property 3, which changed on 2019-01-02
+func newReconcileStub() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/branch"):
+			fmt.Fprint(w, `<branches>`+
+				`<branch><url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/1001</url></branch>`+
+				`<branch><url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/1002</url></branch>`+
+				`</branches>`)
+		case strings.HasSuffix(r.URL.Path, "/1001/property"):
+			fmt.Fprint(w, `<properties><property><prop_id>1</prop_id></property><property><prop_id>2</prop_id></property></properties>`)
+		case strings.HasSuffix(r.URL.Path, "/1002/property"):
+			fmt.Fprint(w, `<properties><property><prop_id>3</prop_id><lastchanged>2019-01-02T03:04:05</lastchanged></property></properties>`)
+		default:
+			fmt.Fprintf(w, `<property id="%s"></property>`, path.Base(r.URL.Path))
+		}
+	}))
+}
+
+// inventorySink is a memorySink reporting when each property was upserted
+type inventorySink struct {
+	*memorySink
+	lastChanged map[uint]time.Time
+}
+
+func (sink *inventorySink) Records() ([]SinkRecord, error) {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	var records []SinkRecord
+	for id := range sink.properties {
+		records = append(records, SinkRecord{PropertyID: id, LastChanged: sink.lastChanged[id]})
+	}
+	return records, nil
+}
+
+func TestReconcilerFindsDifferences(t *testing.T) {
+	server := newReconcileStub()
+	defer server.Close()
+
+	sink := &inventorySink{memorySink: newMemorySink(), lastChanged: map[uint]time.Time{
+		3: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}
+	for _, id := range []uint{2, 3, 4} {
+		sink.properties[id] = &Property{ID: id}
+	}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	reconciler := NewReconciler(api, sink)
+
+	report, err := reconciler.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Live != 3 || len(report.Missing) != 1 || report.Missing[0].Summary.PropertyID != 1 {
+		t.Errorf("Expected [3] live properties with [1] missing but found [%d] and [%+v]", report.Live, report.Missing)
+	}
+	if len(report.Stale) != 1 || report.Stale[0].Summary.PropertyID != 3 || report.Stale[0].Branch.GetClientIDString() != "1002" {
+		t.Errorf("Expected property [3] of branch [1002] to be stale but found [%+v]", report.Stale)
+	}
+	if len(report.Extra) != 1 || report.Extra[0] != 4 {
+		t.Errorf("Expected property [4] to be extra but found [%v]", report.Extra)
+	}
+	if sink.upserts != 0 || sink.deletes != 0 || len(report.Outcomes) != 0 {
+		t.Errorf("Expected the sink to be left alone without auto-fix")
+	}
+
+	if report, err = reconciler.SetAutoFix(true).Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Outcomes) != 3 || sink.upserts != 2 || sink.deletes != 1 {
+		t.Errorf("Expected [3] outcomes, [2] upserts and [1] delete but found [%d], [%d] and [%d]", len(report.Outcomes), sink.upserts, sink.deletes)
+	}
+	for _, id := range []uint{1, 2, 3} {
+		if sink.properties[id] == nil {
+			t.Errorf("Expected property [%d] in the sink after auto-fix", id)
+		}
+	}
+	if _, ok := sink.properties[4]; ok {
+		t.Errorf("Expected property [4] to be deleted by auto-fix")
+	}
+}
+
+// newEmptyListingStub serves the given branches body and an empty property list for every branch
+func newEmptyListingStub(branches string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/branch") {
+			fmt.Fprint(w, branches)
+			return
+		}
+		fmt.Fprint(w, `<properties></properties>`)
+	}))
+}
+
+func TestReconcilerRefusesToEmptyTheSink(t *testing.T) {
+	listings := map[string]string{
+		"no branches": `<branches></branches>`,
+		"empty branch": `<branches>` +
+			`<branch><url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/1001</url></branch>` +
+			`</branches>`,
+	}
+	for name, branches := range listings {
+		server := newEmptyListingStub(branches)
+		sink := &inventorySink{memorySink: newMemorySink()}
+		for _, id := range []uint{1, 2, 3} {
+			sink.properties[id] = &Property{ID: id}
+		}
+		api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+		report, err := NewReconciler(api, sink).SetAutoFix(true).Reconcile(context.Background())
+		server.Close()
+		if !errors.Is(err, ErrTooManyDeletions) {
+			t.Errorf("[%s] Expected [%v] but found [%v]", name, ErrTooManyDeletions, err)
+		}
+		if report == nil || report.Live != 0 || len(report.Extra) != 3 {
+			t.Errorf("[%s] Expected a report with [3] extra properties but found [%+v]", name, report)
+		}
+		if sink.deletes != 0 || len(sink.properties) != 3 {
+			t.Errorf("[%s] Expected the sink to be left alone but found [%d] deletes", name, sink.deletes)
+		}
+	}
+}
+
+func TestReconcilerLimitsDeletions(t *testing.T) {
+	server := newReconcileStub()
+	defer server.Close()
+
+	sink := &inventorySink{memorySink: newMemorySink()}
+	for _, id := range []uint{1, 2, 3, 4, 5} {
+		sink.properties[id] = &Property{ID: id}
+	}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	for _, reconciler := range []*Reconciler{
+		NewReconciler(api, sink).SetAutoFix(true).SetMaxDeletions(1),
+		NewReconciler(api, sink).SetAutoFix(true).SetMaxDeletionFraction(0.2),
+	} {
+		if _, err := reconciler.Reconcile(context.Background()); !errors.Is(err, ErrTooManyDeletions) {
+			t.Errorf("Expected [%v] but found [%v]", ErrTooManyDeletions, err)
+		}
+		if sink.deletes != 0 || sink.upserts != 0 {
+			t.Errorf("Expected the sink to be left alone but found [%d] upserts and [%d] deletes", sink.upserts, sink.deletes)
+		}
+	}
+
+	if _, err := NewReconciler(api, sink).SetAutoFix(true).SetMaxDeletions(2).Reconcile(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if sink.deletes != 2 {
+		t.Errorf("Expected [2] deletes within the limit but found [%d]", sink.deletes)
+	}
+}
@@ -0,0 +1,145 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const HeaderRetryAfterKey = "Retry-After"
+
+// RetryPolicy describes how requests failing with a transport error or one of
+// the RetryableStatusCodes are retried.
+// Contains:
+// MaxAttempts: Total number of attempts, including the first one. Values below 2 disable retries.
+// BaseDelay: Delay before the first retry. It doubles on every further retry.
+// MaxDelay: Upper bound for a single delay, including delays asked for with Retry-After. Zero means no bound.
+// Jitter: Fraction (0-1) of each delay that is randomised, so clients do not retry in lockstep.
+// RetryableStatusCodes: Response status codes worth retrying.
+// OnRetry: Called before sleeping ahead of each retry, e.g. for logging. May be nil.
+type RetryPolicy struct {
+	MaxAttempts          int
+	BaseDelay            time.Duration
+	MaxDelay             time.Duration
+	Jitter               float64
+	RetryableStatusCodes []int
+	OnRetry              func(event RetryEvent)
+}
+
+// RetryEvent describes a failed attempt that is about to be retried
+// Contains:
+// Attempt: The number of the failed attempt, starting at 1
+// URL: The requested URL
+// StatusCode: The response status code, 0 if no response was received
+// Err: The transport error, nil if a response was received
+// Delay: How long the client waits before the next attempt
+type RetryEvent struct {
+	Attempt    int
+	URL        string
+	StatusCode int
+	Err        error
+	Delay      time.Duration
+}
+
+// DefaultRetryPolicy retries rate limited requests, server errors and transport
+// failures up to three times in total
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay:   500 * time.Millisecond,
+		MaxDelay:    30 * time.Second,
+		Jitter:      0.2,
+		RetryableStatusCodes: []int{
+			http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout,
+		},
+	}
+}
+
+// NoRetryPolicy makes every request a single attempt
+func NoRetryPolicy() RetryPolicy {
+	return RetryPolicy{MaxAttempts: 1}
+}
+
+// shouldRetry reports whether another attempt should follow attempt, which
+// either failed with err or received response
+func (policy RetryPolicy) shouldRetry(attempt int, response *http.Response, err error) bool {
+	if attempt >= policy.MaxAttempts {
+		return false
+	}
+	if err != nil {
+		var transportError *TransportError
+		return errors.As(err, &transportError)
+	}
+	for _, statusCode := range policy.RetryableStatusCodes {
+		if response.StatusCode == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns how long to wait after attempt, preferring the server's Retry-After
+func (policy RetryPolicy) delay(attempt int, response *http.Response) time.Duration {
+	if retryAfter, ok := parseRetryAfter(response); ok {
+		return policy.capDelay(retryAfter)
+	}
+	delay := policy.BaseDelay
+	for i := 1; i < attempt && (policy.MaxDelay <= 0 || delay < policy.MaxDelay); i++ {
+		delay *= 2
+	}
+	delay = policy.capDelay(delay)
+	if policy.Jitter > 0 {
+		delay -= time.Duration(policy.Jitter * rand.Float64() * float64(delay))
+	}
+	return delay
+}
+
+func (policy RetryPolicy) capDelay(delay time.Duration) time.Duration {
+	if policy.MaxDelay > 0 && delay > policy.MaxDelay {
+		return policy.MaxDelay
+	}
+	return delay
+}
+
+// wait notifies OnRetry and sleeps before the next attempt, returning early with ctx's error
+func (policy RetryPolicy) wait(ctx context.Context, event RetryEvent) error {
+	if policy.OnRetry != nil {
+		policy.OnRetry(event)
+	}
+	timer := time.NewTimer(event.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date
+func parseRetryAfter(response *http.Response) (time.Duration, bool) {
+	if response == nil {
+		return 0, false
+	}
+	retryAfter := response.Header.Get(HeaderRetryAfterKey)
+	if retryAfter == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(retryAfter); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(retryAfter); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
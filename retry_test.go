@@ -0,0 +1,164 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testRetryPolicy(events *[]RetryEvent) RetryPolicy {
+	policy := DefaultRetryPolicy()
+	policy.BaseDelay = time.Millisecond
+	policy.MaxDelay = 10 * time.Millisecond
+	policy.OnRetry = func(event RetryEvent) {
+		*events = append(*events, event)
+	}
+	return policy
+}
+
+func TestApiRetriesTransientFailures(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`<branches></branches>`))
+	}))
+	defer server.Close()
+
+	var events []RetryEvent
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithRetryPolicy(testRetryPolicy(&events)))
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected [2] retries but found [%d]", len(events))
+	}
+	if events[0].Attempt != 1 || events[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected retry event [%+v]", events[0])
+	}
+}
+
+func TestApiGivesUpAfterMaxAttempts(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	var events []RetryEvent
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithRetryPolicy(testRetryPolicy(&events)))
+	_, err := api.GetBranches()
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected a [500] *APIError but found [%v]", err)
+	}
+	if requests != 3 {
+		t.Errorf("Expected [3] requests but found [%d]", requests)
+	}
+}
+
+func TestApiDoesNotRetryClientErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	var events []RetryEvent
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithRetryPolicy(testRetryPolicy(&events)))
+	if _, err := api.GetBranches(); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected [%v] but found [%v]", ErrNotFound, err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected [1] request but found [%d]", requests)
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if actual := policy.delay(i+1, nil); actual != delay {
+			t.Errorf("Expected [%s] but found [%s] for attempt [%d]", delay, actual, i+1)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := policy.delay(1, nil); delay < 500*time.Millisecond || delay > time.Second {
+			t.Fatalf("Jittered delay [%s] out of range", delay)
+		}
+	}
+}
+
+func TestRetryPolicyHonoursRetryAfter(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: time.Minute}
+	response := &http.Response{Header: http.Header{}}
+
+	response.Header.Set(HeaderRetryAfterKey, "7")
+	if delay := policy.delay(1, response); delay != 7*time.Second {
+		t.Errorf("Expected [%s] but found [%s]", 7*time.Second, delay)
+	}
+
+	response.Header.Set(HeaderRetryAfterKey, "3600")
+	if delay := policy.delay(1, response); delay != time.Minute {
+		t.Errorf("Expected [%s] but found [%s]", time.Minute, delay)
+	}
+
+	response.Header.Set(HeaderRetryAfterKey, time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat))
+	if delay := policy.delay(1, response); delay != 0 {
+		t.Errorf("Expected [0s] but found [%s]", delay)
+	}
+}
+
+func TestFileDownloaderRetriesDownloads(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("image"))
+	}))
+	defer server.Close()
+
+	var events []RetryEvent
+	downloader := &fileDownloader{retryPolicy: testRetryPolicy(&events)}
+	dest := filepath.Join(t.TempDir(), "image.jpg")
+	if err := downloader.downloadFile(context.Background(), server.URL+"/image.jpg", dest); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "image" {
+		t.Errorf("Expected [image] but found [%s]", content)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected [1] retry but found [%d]", len(events))
+	}
+}
+
+func TestFileDownloaderDoesNotRetryBadURLs(t *testing.T) {
+	var events []RetryEvent
+	downloader := &fileDownloader{retryPolicy: testRetryPolicy(&events)}
+	err := downloader.downloadFile(context.Background(), "http://[::1", filepath.Join(t.TempDir(), "image.jpg"))
+	var transportError *TransportError
+	if err == nil || errors.As(err, &transportError) {
+		t.Errorf("Expected the request construction error but found [%v]", err)
+	}
+	if len(events) != 0 {
+		t.Errorf("Expected no retry but found [%d]", len(events))
+	}
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultCheckpointTable is the table SQLCheckpointStore keeps checkpoints in unless told otherwise
+const DefaultCheckpointTable = "vebra_checkpoints"
+
+// SQLCheckpointStore implements CheckpointStore on top of database/sql. Rows are
+// keyed by data feed ID and kind of change. Like SQLTokenStorage it only
+// supports drivers understanding ? placeholders, such as MySQL and SQLite.
+type SQLCheckpointStore struct {
+	db    *sql.DB
+	table string
+}
+
+// NewSQLCheckpointStore returns a SQLCheckpointStore keeping checkpoints in DefaultCheckpointTable
+func NewSQLCheckpointStore(db *sql.DB) *SQLCheckpointStore {
+	return &SQLCheckpointStore{
+		db:    db,
+		table: DefaultCheckpointTable,
+	}
+}
+
+// SetTable sets the table checkpoints are kept in, see CreateCheckpointTable
+func (store *SQLCheckpointStore) SetTable(table string) *SQLCheckpointStore {
+	store.table = table
+	return store
+}
+
+// CreateCheckpointTable creates the table used by SQLCheckpointStore if it does not exist.
+// Times are stored as Unix milliseconds to behave the same with every driver.
+func CreateCheckpointTable(db *sql.DB, table string) error {
+	if err := checkTableName("checkpoint", table); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		data_feed_id VARCHAR(64) NOT NULL,
+		kind VARCHAR(32) NOT NULL,
+		mark BIGINT NOT NULL,
+		PRIMARY KEY (data_feed_id, kind)
+	)`)
+	return err
+}
+
+// LoadCheckpoint reads the checkpoint of a feed and kind of change
+func (store *SQLCheckpointStore) LoadCheckpoint(dataFeedID string, kind CheckpointKind) (time.Time, error) {
+	if err := checkTableName("checkpoint", store.table); err != nil {
+		return time.Time{}, err
+	}
+	var mark int64
+	err := store.db.QueryRow(`SELECT mark FROM `+store.table+` WHERE data_feed_id = ? AND kind = ?`,
+		dataFeedID, string(kind)).Scan(&mark)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fromUnixMillis(mark), nil
+}
+
+// CommitCheckpoint moves the checkpoint of a feed and kind of change forward to mark
+func (store *SQLCheckpointStore) CommitCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error {
+	return store.update(dataFeedID, kind, mark, false)
+}
+
+// RewindCheckpoint sets the checkpoint of a feed and kind of change to mark
+func (store *SQLCheckpointStore) RewindCheckpoint(dataFeedID string, kind CheckpointKind, mark time.Time) error {
+	return store.update(dataFeedID, kind, mark, true)
+}
+
+func (store *SQLCheckpointStore) update(dataFeedID string, kind CheckpointKind, mark time.Time, rewind bool) error {
+	if err := checkTableName("checkpoint", store.table); err != nil {
+		return err
+	}
+	if mark.IsZero() {
+		if !rewind {
+			return nil
+		}
+		_, err := store.db.Exec(`DELETE FROM `+store.table+` WHERE data_feed_id = ? AND kind = ?`, dataFeedID, string(kind))
+		return err
+	}
+	millis := toUnixMillis(mark)
+	update := sqlStatement{`UPDATE ` + store.table + ` SET mark = ? WHERE data_feed_id = ? AND kind = ?`,
+		[]interface{}{millis, dataFeedID, string(kind)}}
+	if !rewind {
+		update.query += ` AND mark < ?`
+		update.args = append(update.args, millis)
+	}
+	return upsertRow(store.db, update,
+		sqlStatement{`INSERT INTO ` + store.table + ` (data_feed_id, kind, mark) VALUES (?, ?, ?)`,
+			[]interface{}{dataFeedID, string(kind), millis}},
+		sqlStatement{`SELECT COUNT(*) FROM ` + store.table + ` WHERE data_feed_id = ? AND kind = ?`,
+			[]interface{}{dataFeedID, string(kind)}})
+}
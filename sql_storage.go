@@ -0,0 +1,59 @@
+package api
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+)
+
+// tableNamePattern restricts table names, which cannot be passed as query parameters
+var tableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// checkTableName rejects table names that could inject SQL
+func checkTableName(kind string, table string) error {
+	if !tableNamePattern.MatchString(table) {
+		return fmt.Errorf("invalid %s table name [%s]", kind, table)
+	}
+	return nil
+}
+
+// sqlStatement is a query with its arguments
+type sqlStatement struct {
+	query string
+	args  []interface{}
+}
+
+// upsertRow writes one row without MySQL's or SQLite's own upsert syntax. update
+// must only change the row when it is to be replaced, insert creates it and count
+// counts the rows with its key. When insert fails because another process
+// inserted the row since update ran, e.g. with a duplicate key error, update is
+// run again. The statements must use the placeholders of the driver behind db.
+func upsertRow(db *sql.DB, update sqlStatement, insert sqlStatement, count sqlStatement) error {
+	if updated, err := execRowsAffected(db, update); err != nil || updated > 0 {
+		return err
+	}
+	_, insertErr := db.Exec(insert.query, insert.args...)
+	if insertErr == nil {
+		return nil
+	}
+	if updated, err := execRowsAffected(db, update); err != nil || updated > 0 {
+		return err
+	}
+	var rows int
+	if err := db.QueryRow(count.query, count.args...).Scan(&rows); err != nil {
+		return err
+	}
+	if rows == 0 {
+		return insertErr
+	}
+	// the row exists and update declined to replace it
+	return nil
+}
+
+func execRowsAffected(db *sql.DB, statement sqlStatement) (int64, error) {
+	result, err := db.Exec(statement.query, statement.args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"database/sql"
+	"time"
+)
+
+// DefaultTokenTable is the table SQLTokenStorage keeps tokens in unless told otherwise
+const DefaultTokenTable = "vebra_tokens"
+
+// SQLTokenStorage implements the TokenStorage interface on top of database/sql,
+// so the tokens of many data feeds live in one table shared by every worker.
+// Rows are keyed by data feed ID and username. Queries use ? placeholders, so
+// only drivers understanding them, such as MySQL and SQLite, are supported.
+// Postgres drivers like lib/pq and pgx reject them.
+type SQLTokenStorage struct {
+	db         *sql.DB
+	table      string
+	dataFeedID string
+	username   string
+}
+
+// NewSQLTokenStorage returns a SQLTokenStorage keeping the token of the given
+// data feed and user in DefaultTokenTable
+func NewSQLTokenStorage(db *sql.DB, dataFeedID string, username string) *SQLTokenStorage {
+	return &SQLTokenStorage{
+		db:         db,
+		table:      DefaultTokenTable,
+		dataFeedID: dataFeedID,
+		username:   username,
+	}
+}
+
+// SetTable sets the table tokens are kept in, see CreateTokenTable
+func (ts *SQLTokenStorage) SetTable(table string) *SQLTokenStorage {
+	ts.table = table
+	return ts
+}
+
+// CreateTokenTable creates the table used by SQLTokenStorage if it does not exist.
+// Times are stored as Unix milliseconds to behave the same with every driver.
+func CreateTokenTable(db *sql.DB, table string) error {
+	if err := checkTableName("token", table); err != nil {
+		return err
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (
+		data_feed_id VARCHAR(64) NOT NULL,
+		username VARCHAR(255) NOT NULL,
+		token VARCHAR(1024) NOT NULL,
+		acquired_at BIGINT NOT NULL,
+		expires_at BIGINT NOT NULL,
+		PRIMARY KEY (data_feed_id, username)
+	)`)
+	return err
+}
+
+// Save persists the Token unless the table already holds one acquired later,
+// e.g. by another worker. Workers saving their first token at the same time
+// do not fail on each other's insert.
+func (ts *SQLTokenStorage) Save(token Token) error {
+	if err := checkTableName("token", ts.table); err != nil {
+		return err
+	}
+	acquiredAt, expiresAt := toUnixMillis(token.timeSet), toUnixMillis(token.expiresAt)
+	return upsertRow(ts.db,
+		sqlStatement{`UPDATE ` + ts.table + ` SET token = ?, acquired_at = ?, expires_at = ?
+			WHERE data_feed_id = ? AND username = ? AND acquired_at <= ?`,
+			[]interface{}{token.tokenString, acquiredAt, expiresAt, ts.dataFeedID, ts.username, acquiredAt}},
+		sqlStatement{`INSERT INTO ` + ts.table + ` (data_feed_id, username, token, acquired_at, expires_at)
+			VALUES (?, ?, ?, ?, ?)`,
+			[]interface{}{ts.dataFeedID, ts.username, token.tokenString, acquiredAt, expiresAt}},
+		sqlStatement{`SELECT COUNT(*) FROM ` + ts.table + ` WHERE data_feed_id = ? AND username = ?`,
+			[]interface{}{ts.dataFeedID, ts.username}})
+}
+
+// Load loads the persisted Token, returning an invalid Token if none was saved yet
+func (ts *SQLTokenStorage) Load() (*Token, error) {
+	if err := checkTableName("token", ts.table); err != nil {
+		return nil, err
+	}
+	var tokenString string
+	var acquiredAt, expiresAt int64
+	err := ts.db.QueryRow(`SELECT token, acquired_at, expires_at FROM `+ts.table+`
+		WHERE data_feed_id = ? AND username = ?`, ts.dataFeedID, ts.username).Scan(&tokenString, &acquiredAt, &expiresAt)
+	if err == sql.ErrNoRows {
+		token := NewToken("")
+		token.Invalidate()
+		return token, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return RestoreToken(tokenString, fromUnixMillis(acquiredAt), fromUnixMillis(expiresAt)), nil
+}
+
+func toUnixMillis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(millis int64) time.Time {
+	if millis == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, millis*int64(time.Millisecond))
+}
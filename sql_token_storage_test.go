@@ -0,0 +1,170 @@
+package api
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+func newTestTokenDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "tokens.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := CreateTokenTable(db, DefaultTokenTable); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestSQLTokenStorageRoundTrip(t *testing.T) {
+	ts := NewSQLTokenStorage(newTestTokenDB(t), "ABCDEFG", "user")
+
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.IsValid() {
+		t.Errorf("Expected an invalid token before saving but found [%+v]", token)
+	}
+
+	saved := NewToken("token-1")
+	if err := ts.Save(*saved); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.GetToken() != "token-1" || !loaded.IsValid() {
+		t.Errorf("Expected valid token [token-1] but found [%+v]", loaded)
+	}
+	if loaded.ExpiresAt().Sub(saved.ExpiresAt()).Abs() >= time.Millisecond {
+		t.Errorf("Expected expiry [%s] but found [%s]", saved.ExpiresAt(), loaded.ExpiresAt())
+	}
+}
+
+func TestSQLTokenStorageKeysByDataFeedAndUser(t *testing.T) {
+	db := newTestTokenDB(t)
+	storages := map[string]*SQLTokenStorage{
+		"feed-a-user":  NewSQLTokenStorage(db, "ABCDEFG", "user"),
+		"feed-b-user":  NewSQLTokenStorage(db, "HIJKLMN", "user"),
+		"feed-a-other": NewSQLTokenStorage(db, "ABCDEFG", "other"),
+	}
+	for name, ts := range storages {
+		if err := ts.Save(*NewToken(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, ts := range storages {
+		token, err := ts.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token.GetToken() != name {
+			t.Errorf("Expected [%s] but found [%s]", name, token.GetToken())
+		}
+	}
+}
+
+func TestSQLTokenStorageKeepsNewerToken(t *testing.T) {
+	db := newTestTokenDB(t)
+	newer := NewToken("newer")
+	older := RestoreToken("older", newer.AcquiredAt().Add(-time.Minute), newer.ExpiresAt().Add(-time.Minute))
+
+	if err := NewSQLTokenStorage(db, "ABCDEFG", "user").Save(*newer); err != nil {
+		t.Fatal(err)
+	}
+	other := NewSQLTokenStorage(db, "ABCDEFG", "user")
+	if err := other.Save(*older); err != nil {
+		t.Fatal(err)
+	}
+	token, err := other.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.GetToken() != "newer" {
+		t.Errorf("Expected [newer] but found [%s]", token.GetToken())
+	}
+}
+
+// racingConnector opens SQLite connections calling beforeInsert ahead of every
+// INSERT, to let another worker write in between a storage's statements
+type racingConnector struct {
+	fileName     string
+	beforeInsert func()
+}
+
+func (connector *racingConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := connector.Driver().Open(connector.fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &racingConn{Conn: conn, beforeInsert: connector.beforeInsert}, nil
+}
+
+func (connector *racingConnector) Driver() driver.Driver {
+	return &sqlite3.SQLiteDriver{}
+}
+
+type racingConn struct {
+	driver.Conn
+	beforeInsert func()
+}
+
+func (conn *racingConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(strings.TrimSpace(query), "INSERT") {
+		conn.beforeInsert()
+	}
+	return conn.Conn.Prepare(query)
+}
+
+func TestSQLTokenStorageSurvivesConcurrentFirstSave(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "tokens.db")
+	other, err := sql.Open("sqlite3", fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := CreateTokenTable(other, DefaultTokenTable); err != nil {
+		t.Fatal(err)
+	}
+
+	// another worker saves its first, older token just before this one inserts
+	otherToken := RestoreToken("other", time.Now().Add(-time.Minute), time.Now().Add(time.Hour))
+	db := sql.OpenDB(&racingConnector{fileName: fileName, beforeInsert: func() {
+		if err := NewSQLTokenStorage(other, "ABCDEFG", "user").Save(*otherToken); err != nil {
+			t.Error(err)
+		}
+	}})
+	defer db.Close()
+
+	ts := NewSQLTokenStorage(db, "ABCDEFG", "user")
+	if err := ts.Save(*NewToken("token-1")); err != nil {
+		t.Fatalf("Expected the duplicate insert to be retried as an update but found [%v]", err)
+	}
+	token, err := ts.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.GetToken() != "token-1" {
+		t.Errorf("Expected [token-1] but found [%s]", token.GetToken())
+	}
+}
+
+func TestSQLTokenStorageRejectsInvalidTable(t *testing.T) {
+	db := newTestTokenDB(t)
+	if err := CreateTokenTable(db, "tokens; DROP TABLE vebra_tokens"); err == nil {
+		t.Errorf("Expected an error for an invalid table name")
+	}
+	if _, err := NewSQLTokenStorage(db, "ABCDEFG", "user").SetTable("bad name").Load(); err == nil {
+		t.Errorf("Expected an error for an invalid table name")
+	}
+}
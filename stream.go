@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"time"
+)
+
+// streamDecoder is implemented by the out values of requests whose response is
+// decoded element by element instead of being read into memory first
+type streamDecoder interface {
+	decodeStream(decoder *xml.Decoder) error
+}
+
+// elementStream decodes each child element of the document root named name
+// with decode, e.g. every property of a properties list
+type elementStream struct {
+	name   string
+	decode func(decoder *xml.Decoder, start *xml.StartElement) error
+}
+
+func (stream *elementStream) decodeStream(decoder *xml.Decoder) error {
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			if depth == 1 && element.Name.Local == stream.name {
+				// DecodeElement consumes the element up to and including its end
+				if err = stream.decode(decoder, &element); err != nil {
+					return err
+				}
+				continue
+			}
+			depth++
+		case xml.EndElement:
+			depth--
+		}
+	}
+}
+
+// callbackError carries the error returned by the callback of an Iterate
+// method, so it is not mistaken for a decoding failure
+type callbackError struct {
+	err error
+}
+
+func (e *callbackError) Error() string {
+	return e.err.Error()
+}
+
+func (e *callbackError) Unwrap() error {
+	return e.err
+}
+
+// fromCallback wraps a non-nil error returned by an Iterate callback
+func fromCallback(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &callbackError{err}
+}
+
+// isCallbackError tells whether err was returned by an Iterate callback
+func isCallbackError(err error) bool {
+	var fromCallback *callbackError
+	return errors.As(err, &fromCallback)
+}
+
+// iterationError returns the error of an Iterate callback as it was returned
+func iterationError(err error) error {
+	var fromCallback *callbackError
+	if errors.As(err, &fromCallback) {
+		return fromCallback.err
+	}
+	return err
+}
+
+// IterateProperties calls fn with each property summary of the branch while the
+// response is being decoded, so memory use does not grow with the number of
+// properties. Iteration stops at the first error returned by fn, which is
+// returned as is. fn may have been called for some properties when a later part
+// of the response fails to decode.
+func (api *Api) IterateProperties(branchSummary *BranchSummary, fn func(PropertySummary) error) error {
+	return api.IteratePropertiesContext(context.Background(), branchSummary, fn)
+}
+
+// IteratePropertiesContext is like IterateProperties but aborts the request when ctx is done
+func (api *Api) IteratePropertiesContext(ctx context.Context, branchSummary *BranchSummary, fn func(PropertySummary) error) error {
+	propertiesURLBuilder := new(URLGetPropertiesBuilder)
+	propertiesURLBuilder.SetBaseURL(api.baseURL)
+	propertiesURLBuilder.SetDataFeedID(api.dataFeedId)
+	propertiesURLBuilder.SetClientID(branchSummary.GetClientIDString())
+	stream := &elementStream{name: "property", decode: func(decoder *xml.Decoder, start *xml.StartElement) error {
+		var summary PropertySummary
+		if err := decoder.DecodeElement(&summary, start); err != nil {
+			return err
+		}
+		return fromCallback(fn(summary))
+	}}
+	return iterationError(api.doRequest(ctx, propertiesURLBuilder, stream))
+}
+
+// IterateChangedProperties calls fn with each property changed since the given
+// time while the response is being decoded, see IterateProperties
+func (api *Api) IterateChangedProperties(since time.Time, fn func(ChangedPropertySummary) error) error {
+	return api.IterateChangedPropertiesContext(context.Background(), since, fn)
+}
+
+// IterateChangedPropertiesContext is like IterateChangedProperties but aborts the request when ctx is done
+func (api *Api) IterateChangedPropertiesContext(ctx context.Context, since time.Time, fn func(ChangedPropertySummary) error) error {
+	propertiesURLBuilder := new(URLGetChangedPropertiesBuilder)
+	propertiesURLBuilder.SetBaseURL(api.baseURL)
+	propertiesURLBuilder.SetDataFeedID(api.dataFeedId)
+	propertiesURLBuilder.SetSince(since)
+	stream := &elementStream{name: "property", decode: func(decoder *xml.Decoder, start *xml.StartElement) error {
+		var summary ChangedPropertySummary
+		if err := decoder.DecodeElement(&summary, start); err != nil {
+			return err
+		}
+		return fromCallback(fn(summary))
+	}}
+	return iterationError(api.doRequest(ctx, propertiesURLBuilder, stream))
+}
+
+// IterateChangedFiles calls fn with each file changed since the given time
+// while the response is being decoded, see IterateProperties
+func (api *Api) IterateChangedFiles(since time.Time, fn func(ChangedFileSummary) error) error {
+	return api.IterateChangedFilesContext(context.Background(), since, fn)
+}
+
+// IterateChangedFilesContext is like IterateChangedFiles but aborts the request when ctx is done
+func (api *Api) IterateChangedFilesContext(ctx context.Context, since time.Time, fn func(ChangedFileSummary) error) error {
+	urlGetChangedFilesBuilder := new(URLGetChangedFilesBuilder)
+	urlGetChangedFilesBuilder.SetBaseURL(api.baseURL)
+	urlGetChangedFilesBuilder.SetDataFeedID(api.dataFeedId)
+	urlGetChangedFilesBuilder.SetSince(since)
+	stream := &elementStream{name: "file", decode: func(decoder *xml.Decoder, start *xml.StartElement) error {
+		var summary ChangedFileSummary
+		if err := decoder.DecodeElement(&summary, start); err != nil {
+			return err
+		}
+		return fromCallback(fn(summary))
+	}}
+	return iterationError(api.doRequest(ctx, urlGetChangedFilesBuilder, stream))
+}
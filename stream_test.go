@@ -0,0 +1,189 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// propertySummariesBody returns a properties list response with count properties
+func propertySummariesBody(count int) string {
+	body := new(strings.Builder)
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><properties>`)
+	for i := 1; i <= count; i++ {
+		fmt.Fprintf(body, `<property><prop_id>%d</prop_id><lastchanged>2019-01-02T03:04:05</lastchanged>`+
+			`<url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741/property/%d</url></property>`, i, i)
+	}
+	body.WriteString(`</properties>`)
+	return body.String()
+}
+
+func TestIterateProperties(t *testing.T) {
+	stub := newStubVebra(propertySummariesBody(100))
+	defer stub.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	branch := &BranchSummary{Url: stub.URL + "/branch/3741"}
+	var ids []uint
+	err := api.IterateProperties(branch, func(summary PropertySummary) error {
+		ids = append(ids, summary.PropertyID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 100 || ids[0] != 1 || ids[99] != 100 {
+		t.Errorf("Expected properties [1] to [100] but found [%v]", ids)
+	}
+
+	properties, err := api.GetProperties(branch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(properties.Properties) != len(ids) {
+		t.Errorf("Expected [%d] properties from GetProperties but found [%d]", len(ids), len(properties.Properties))
+	}
+}
+
+func TestIteratePropertiesStopsAtCallbackError(t *testing.T) {
+	stub := newStubVebra(propertySummariesBody(100))
+	defer stub.Close()
+
+	logger := new(recordingLogger)
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithLogger(logger),
+		WithResponseCache(NewMemoryResponseCache(), time.Hour))
+	branch := &BranchSummary{Url: stub.URL + "/branch/3741"}
+	stop := errors.New("stop")
+	calls := 0
+	err := api.IterateProperties(branch, func(summary PropertySummary) error {
+		calls++
+		if calls == 10 {
+			return stop
+		}
+		return nil
+	})
+	if err != stop {
+		t.Errorf("Expected [%v] but found [%v]", stop, err)
+	}
+	if calls != 10 {
+		t.Errorf("Expected [10] calls but found [%d]", calls)
+	}
+	if found := logger.count(LogUnmarshalFailed); found != 0 {
+		t.Errorf("Expected no event [%s] but found [%d]", LogUnmarshalFailed, found)
+	}
+
+	// the partly read response must not have been cached
+	calls = 0
+	if err = api.IterateProperties(branch, func(PropertySummary) error { calls++; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 100 {
+		t.Errorf("Expected [100] calls but found [%d]", calls)
+	}
+}
+
+func TestIterateChangedFiles(t *testing.T) {
+	stub := newStubVebra(`<files>` +
+		`<file><file_id>1</file_id><file_propid>26858499</file_propid><url>http://example.com/1.jpg</url></file>` +
+		`<file><file_id>2</file_id><file_propid>26858499</file_propid><deleted>true</deleted></file>` +
+		`</files>`)
+	defer stub.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	var files []ChangedFileSummary
+	err := api.IterateChangedFiles(time.Now(), func(summary ChangedFileSummary) error {
+		files = append(files, summary)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].Url != "http://example.com/1.jpg" || !files[1].Deleted {
+		t.Errorf("Expected two changed files but found [%+v]", files)
+	}
+}
+
+// peakHeap keeps the largest live heap seen by sample
+type peakHeap struct {
+	peak uint64
+}
+
+func (heap *peakHeap) sample() {
+	if live := heapBaseline(); live > heap.peak {
+		heap.peak = live
+	}
+}
+
+// report reports the largest heap sampled during the benchmark, relative to
+// the heap in use when it started
+func (heap *peakHeap) report(b *testing.B, baseline uint64) {
+	if heap.peak < baseline {
+		heap.peak = baseline
+	}
+	b.ReportMetric(float64(heap.peak-baseline), "peak-heap-B")
+}
+
+// heapBaseline returns the live heap after a garbage collection
+func heapBaseline() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+func benchmarkProperties(b *testing.B, count int, iterate bool) {
+	body := propertySummariesBody(count)
+	// written without copying the body, so the heap measures the client only
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	branch := &BranchSummary{Url: server.URL + "/branch/3741"}
+	heap := new(peakHeap)
+	baseline := heapBaseline()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if iterate {
+			seen := 0
+			err := api.IterateProperties(branch, func(PropertySummary) error {
+				if seen++; seen%(count/10) == 0 {
+					heap.sample()
+				}
+				return nil
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+		} else {
+			properties, err := api.GetProperties(branch)
+			if err != nil {
+				b.Fatal(err)
+			}
+			heap.sample()
+			runtime.KeepAlive(properties)
+		}
+	}
+	b.StopTimer()
+	heap.report(b, baseline)
+}
+
+func BenchmarkGetProperties(b *testing.B) {
+	for _, count := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(count), func(b *testing.B) { benchmarkProperties(b, count, false) })
+	}
+}
+
+func BenchmarkIterateProperties(b *testing.B) {
+	for _, count := range []int{1000, 10000, 100000} {
+		b.Run(fmt.Sprint(count), func(b *testing.B) { benchmarkProperties(b, count, true) })
+	}
+}
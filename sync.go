@@ -0,0 +1,336 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSyncConcurrency is the number of properties a Syncer fetches at once unless told otherwise
+const DefaultSyncConcurrency = 4
+
+// DefaultSyncOverlap is how far before its high-water mark a Syncer asks for
+// changes, so a clock running ahead of the API's does not make it miss any
+const DefaultSyncOverlap = time.Minute
+
+// ErrNoHighWaterMark is returned by Syncer.Sync when neither its storage nor SetStartTime says where to start
+var ErrNoHighWaterMark = errors.New("no high-water mark to sync from")
+
+// Sink receives the changes found by a Syncer. A Syncer calls Upsert and Delete
+// from up to its concurrency goroutines at once, so implementations must be safe
+// for concurrent use. A property may be handed to Upsert or Delete again after
+// it was applied, e.g. when a sync is retried, so both must leave an already
+// applied change as it is.
+type Sink interface {
+	Upsert(property *Property) error
+	Delete(propertyID uint) error
+}
+
+// BatchSink is a Sink that applies changes in batches, e.g. in a transaction.
+// Syncer.Sync calls Commit once it handed over every change of a sync, and only
+// advances its high-water mark after Commit acknowledged them.
+type BatchSink interface {
+	Sink
+	Commit() error
+}
+
+// HighWaterMarkStorage persists the time up to which a Syncer has applied every change
+type HighWaterMarkStorage interface {
+	// LoadHighWaterMark returns the zero time when no mark was saved yet
+	LoadHighWaterMark() (time.Time, error)
+	SaveHighWaterMark(mark time.Time) error
+}
+
+// FileHighWaterMarkStorage keeps a high-water mark in a file
+type FileHighWaterMarkStorage struct {
+	fileName string
+}
+
+// NewFileHighWaterMarkStorage returns a HighWaterMarkStorage writing to fileName
+func NewFileHighWaterMarkStorage(fileName string) *FileHighWaterMarkStorage {
+	return &FileHighWaterMarkStorage{fileName: fileName}
+}
+
+// LoadHighWaterMark reads the mark, the zero time if the file does not exist
+func (storage *FileHighWaterMarkStorage) LoadHighWaterMark() (time.Time, error) {
+	data, err := ioutil.ReadFile(storage.fileName)
+	if os.IsNotExist(err) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	mark, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("high-water mark file [%s] is invalid: %w", storage.fileName, err)
+	}
+	return mark, nil
+}
+
+// SaveHighWaterMark replaces the mark in the file
+func (storage *FileHighWaterMarkStorage) SaveHighWaterMark(mark time.Time) error {
+	return writeFileAtomic(storage.fileName, []byte(mark.UTC().Format(time.RFC3339Nano)+"\n"), 0600)
+}
+
+// SyncAction is what a Syncer does with a changed property
+type SyncAction string
+
+const (
+	SyncUpsert SyncAction = "upsert"
+	SyncDelete SyncAction = "delete"
+)
+
+// SyncOutcome is the result of applying one changed property to the Sink
+// Contains:
+// PropertyID: The property's ID
+// Action: Whether the property is upserted or deleted
+// Err: Why fetching or applying the property failed, nil if it succeeded
+// Skipped: Err is permanent, e.g. the property's URL is not found, so retrying
+// would fail again. The property is logged and passed over instead.
+type SyncOutcome struct {
+	PropertyID uint
+	Action     SyncAction
+	Err        error
+	Skipped    bool
+}
+
+// SyncResult describes one run of Syncer.Sync
+// Contains:
+// Since: The time changes were asked for since
+// Until: The high-water mark saved if every property was applied or skipped
+// Outcomes: One outcome per changed property, in the order the API listed them
+type SyncResult struct {
+	Since    time.Time
+	Until    time.Time
+	Outcomes []SyncOutcome
+}
+
+// Failed returns the outcomes of the properties that could not be applied,
+// including the skipped ones
+func (result *SyncResult) Failed() []SyncOutcome {
+	return failedOutcomes(result.Outcomes)
+}
+
+// Skipped returns the outcomes of the properties passed over after a permanent error
+func (result *SyncResult) Skipped() []SyncOutcome {
+	var skipped []SyncOutcome
+	for _, outcome := range result.Outcomes {
+		if outcome.Skipped {
+			skipped = append(skipped, outcome)
+		}
+	}
+	return skipped
+}
+
+func failedOutcomes(outcomes []SyncOutcome) []SyncOutcome {
+	var failed []SyncOutcome
+	for _, outcome := range outcomes {
+		if outcome.Err != nil {
+			failed = append(failed, outcome)
+		}
+	}
+	return failed
+}
+
+// Syncer applies the properties changed since its high-water mark to a Sink.
+// The mark only advances once every change was applied or skipped, so a failed
+// sync is repeated in full by the next one. Use CheckpointHighWaterMark to keep the mark
+// in a CheckpointStore.
+type Syncer struct {
+	api         *Api
+	sink        Sink
+	storage     HighWaterMarkStorage
+	concurrency int
+	overlap     time.Duration
+	startTime   time.Time
+}
+
+// NewSyncer returns a Syncer applying the changes of api's feed to sink, with its high-water mark kept in storage
+func NewSyncer(api *Api, sink Sink, storage HighWaterMarkStorage) *Syncer {
+	return &Syncer{
+		api:         api,
+		sink:        sink,
+		storage:     storage,
+		concurrency: DefaultSyncConcurrency,
+		overlap:     DefaultSyncOverlap,
+	}
+}
+
+// SetConcurrency sets the number of properties fetched and applied at once
+func (syncer *Syncer) SetConcurrency(concurrency int) *Syncer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	syncer.concurrency = concurrency
+	return syncer
+}
+
+// SetOverlap sets how far before the high-water mark changes are asked for
+func (syncer *Syncer) SetOverlap(overlap time.Duration) *Syncer {
+	syncer.overlap = overlap
+	return syncer
+}
+
+// SetStartTime sets where the first sync starts when the storage holds no high-water mark yet
+func (syncer *Syncer) SetStartTime(startTime time.Time) *Syncer {
+	syncer.startTime = startTime
+	return syncer
+}
+
+// Sync fetches the properties changed since the high-water mark, upserts the
+// updated ones and deletes the deleted ones. When the API lists a property more
+// than once, only its last change is applied. A property failing permanently,
+// because the API does not find it or reports it deleted, is logged and
+// skipped so it cannot hold the mark back forever. If any other property
+// fails, the result is returned with a *SyncError and the mark is left where
+// it was.
+func (syncer *Syncer) Sync(ctx context.Context) (*SyncResult, error) {
+	since, err := syncer.storage.LoadHighWaterMark()
+	if err != nil {
+		return nil, err
+	}
+	if since.IsZero() {
+		if syncer.startTime.IsZero() {
+			return nil, ErrNoHighWaterMark
+		}
+		since = syncer.startTime
+	} else {
+		since = since.Add(-syncer.overlap)
+	}
+
+	result := &SyncResult{Since: since, Until: time.Now()}
+	var summaries []ChangedPropertySummary
+	positions := make(map[uint]int)
+	err = syncer.api.IterateChangedPropertiesContext(ctx, since, func(summary ChangedPropertySummary) error {
+		if i, ok := positions[summary.PropertyID]; ok {
+			summaries[i] = summary
+			return nil
+		}
+		positions[summary.PropertyID] = len(summaries)
+		summaries = append(summaries, summary)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Outcomes = syncer.applyAll(ctx, summaries)
+	if batch, ok := syncer.sink.(BatchSink); ok && len(summaries) > 0 {
+		if err = batch.Commit(); err != nil {
+			return result, fmt.Errorf("sink did not commit the sync: %w", err)
+		}
+	}
+	var failed []SyncOutcome
+	for _, outcome := range result.Failed() {
+		if outcome.Skipped {
+			syncer.api.logSyncSkipped(ctx, outcome)
+			continue
+		}
+		failed = append(failed, outcome)
+	}
+	if len(failed) > 0 {
+		return result, &SyncError{Outcomes: failed}
+	}
+	if err = syncer.storage.SaveHighWaterMark(result.Until); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// applyAll applies the summaries with up to the syncer's concurrency at once
+func (syncer *Syncer) applyAll(ctx context.Context, summaries []ChangedPropertySummary) []SyncOutcome {
+	outcomes := make([]SyncOutcome, len(summaries))
+	for i, summary := range summaries {
+		outcomes[i] = SyncOutcome{PropertyID: summary.PropertyID, Action: syncAction(summary)}
+	}
+	errs := runConcurrently(ctx, syncer.concurrency, len(summaries), func(ctx context.Context, i int) (err error) {
+		outcomes[i].Skipped, err = syncer.apply(ctx, summaries[i])
+		return err
+	})
+	for i, err := range errs {
+		outcomes[i].Err = err
+	}
+	return outcomes
+}
+
+// isPermanentSyncError tells whether fetching a property failed in a way retrying cannot fix
+func isPermanentSyncError(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, ErrPropertyDeleted)
+}
+
+// apply hands a change to the sink. skipped tells whether fetching the property
+// failed permanently; errors of the sink never skip a change.
+func (syncer *Syncer) apply(ctx context.Context, summary ChangedPropertySummary) (skipped bool, err error) {
+	if syncAction(summary) == SyncDelete {
+		return false, syncer.sink.Delete(summary.PropertyID)
+	}
+	property, err := syncer.api.GetChangedPropertyContext(ctx, &summary)
+	if err != nil {
+		return isPermanentSyncError(err), err
+	}
+	return false, syncer.sink.Upsert(property)
+}
+
+// runConcurrently calls fn for every index below count, up to concurrency calls
+// at once, and returns their errors by index. Calls not yet started when ctx is
+// done fail with ctx's error.
+func runConcurrently(ctx context.Context, concurrency int, count int, fn func(ctx context.Context, i int) error) []error {
+	errs := make([]error, count)
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, concurrency)
+	for i := 0; i < count; i++ {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			<-semaphore
+			errs[i] = err
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			errs[i] = fn(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func syncAction(summary ChangedPropertySummary) SyncAction {
+	if summary.LastAction == Deleted {
+		return SyncDelete
+	}
+	return SyncUpsert
+}
+
+// SyncError collects the properties that failed in Syncer.Sync
+type SyncError struct {
+	Outcomes []SyncOutcome
+}
+
+func (e *SyncError) Error() string {
+	messages := make([]string, len(e.Outcomes))
+	for i, outcome := range e.Outcomes {
+		messages[i] = fmt.Sprintf("%s property [%d]: %s", outcome.Action, outcome.PropertyID, outcome.Err)
+	}
+	return fmt.Sprintf("[%d] properties failed to sync: %s", len(e.Outcomes), strings.Join(messages, "; "))
+}
+
+// Unwrap lets errors.Is and errors.As look at every property's error
+func (e *SyncError) Unwrap() []error {
+	errs := make([]error, len(e.Outcomes))
+	for i, outcome := range e.Outcomes {
+		errs[i] = outcome.Err
+	}
+	return errs
+}
@@ -0,0 +1,200 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newSyncStub serves a changed properties list and the properties it links to.
+// Asking for a property in failing is answered with 500.
+func newSyncStub(changes string, failing ...string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/branch/") {
+			fmt.Fprint(w, changes)
+			return
+		}
+		id := path.Base(r.URL.Path)
+		for _, failed := range failing {
+			if id == failed {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+		fmt.Fprintf(w, `<property id="%s"><address><town>Leeds</town></address></property>`, id)
+	}))
+}
+
+// changedPropertiesBody lists each of the given property IDs with its action
+func changedPropertiesBody(changes ...string) string {
+	body := "<propertieschanged>"
+	for _, change := range changes {
+		parts := strings.SplitN(change, ":", 2)
+		body += fmt.Sprintf(`<property><propid>%s</propid><action>%s</action>`+
+			`<url>http://webservices.vebra.com/export/ABCDEFG/v10/branch/3741/property/%s</url></property>`, parts[0], parts[1], parts[0])
+	}
+	return body + "</propertieschanged>"
+}
+
+type memorySink struct {
+	mutex      sync.Mutex
+	properties map[uint]*Property
+	upserts    int
+	deletes    int
+}
+
+func newMemorySink() *memorySink {
+	return &memorySink{properties: make(map[uint]*Property)}
+}
+
+func (sink *memorySink) Upsert(property *Property) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.upserts++
+	sink.properties[property.ID] = property
+	return nil
+}
+
+func (sink *memorySink) Delete(propertyID uint) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.deletes++
+	delete(sink.properties, propertyID)
+	return nil
+}
+
+func TestSyncerAppliesChanges(t *testing.T) {
+	server := newSyncStub(changedPropertiesBody("1:updated", "2:updated", "4:deleted", "1:updated"))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	sink := newMemorySink()
+	sink.properties[4] = &Property{ID: 4}
+	storage := NewFileHighWaterMarkStorage(filepath.Join(t.TempDir(), "mark"))
+	syncer := NewSyncer(api, sink, storage).SetStartTime(time.Now().Add(-time.Hour))
+
+	result, err := syncer.Sync(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Outcomes) != 3 || sink.upserts != 2 || sink.deletes != 1 {
+		t.Errorf("Expected [3] outcomes, [2] upserts and [1] delete but found [%d], [%d] and [%d]", len(result.Outcomes), sink.upserts, sink.deletes)
+	}
+	if _, ok := sink.properties[4]; ok || sink.properties[1] == nil || sink.properties[2] == nil {
+		t.Errorf("Expected properties [1] and [2] in the sink but found [%v]", sink.properties)
+	}
+
+	mark, err := storage.LoadHighWaterMark()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !mark.Equal(result.Until) {
+		t.Errorf("Expected high-water mark [%s] but found [%s]", result.Until, mark)
+	}
+	if result, err = syncer.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Since.Equal(mark.Add(-DefaultSyncOverlap)) {
+		t.Errorf("Expected the next sync to start at [%s] but found [%s]", mark.Add(-DefaultSyncOverlap), result.Since)
+	}
+}
+
+func TestSyncerKeepsHighWaterMarkOnFailure(t *testing.T) {
+	server := newSyncStub(changedPropertiesBody("1:updated", "3:updated"), "3")
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithRetryPolicy(NoRetryPolicy()))
+	storage := NewFileHighWaterMarkStorage(filepath.Join(t.TempDir(), "mark"))
+	syncer := NewSyncer(api, newMemorySink(), storage)
+	if _, err := syncer.Sync(context.Background()); err != ErrNoHighWaterMark {
+		t.Errorf("Expected [%v] but found [%v]", ErrNoHighWaterMark, err)
+	}
+
+	result, err := syncer.SetStartTime(time.Now().Add(-time.Hour)).Sync(context.Background())
+	var syncError *SyncError
+	if !errors.As(err, &syncError) || len(syncError.Outcomes) != 1 || syncError.Outcomes[0].PropertyID != 3 {
+		t.Fatalf("Expected property [3] to fail but found [%v]", err)
+	}
+	var apiError *APIError
+	if !errors.As(err, &apiError) || apiError.StatusCode != http.StatusInternalServerError {
+		t.Errorf("Expected the API error of property [3] but found [%v]", err)
+	}
+	if len(result.Failed()) != 1 || result.Outcomes[0].Err != nil {
+		t.Errorf("Expected only property [3] to fail but found [%+v]", result.Outcomes)
+	}
+	if mark, err := storage.LoadHighWaterMark(); err != nil || !mark.IsZero() {
+		t.Errorf("Expected no high-water mark but found [%s] [%v]", mark, err)
+	}
+}
+
+func TestSyncerSkipsPermanentFailures(t *testing.T) {
+	stub := newSyncStub(changedPropertiesBody("1:updated", "5:updated"))
+	defer stub.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/property/5") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		stub.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	logger := new(recordingLogger)
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithLogger(logger))
+	storage := NewFileHighWaterMarkStorage(filepath.Join(t.TempDir(), "mark"))
+	result, err := NewSyncer(api, newMemorySink(), storage).SetStartTime(time.Now().Add(-time.Hour)).Sync(context.Background())
+	if err != nil {
+		t.Fatalf("Expected the missing property to be skipped but found [%v]", err)
+	}
+	if skipped := result.Skipped(); len(skipped) != 1 || skipped[0].PropertyID != 5 || !errors.Is(skipped[0].Err, ErrNotFound) {
+		t.Errorf("Expected property [5] to be skipped as not found but found [%+v]", result.Outcomes)
+	}
+	if logger.count(LogSyncSkipped) != 1 {
+		t.Errorf("Expected [1] [%s] event but found [%d]", LogSyncSkipped, logger.count(LogSyncSkipped))
+	}
+	if mark, err := storage.LoadHighWaterMark(); err != nil || !mark.Equal(result.Until) {
+		t.Errorf("Expected high-water mark [%s] but found [%s] [%v]", result.Until, mark, err)
+	}
+}
+
+// notFoundSink is a memorySink failing to delete properties it does not hold
+type notFoundSink struct {
+	*memorySink
+}
+
+func (sink *notFoundSink) Delete(propertyID uint) error {
+	sink.mutex.Lock()
+	_, ok := sink.properties[propertyID]
+	sink.mutex.Unlock()
+	if !ok {
+		return fmt.Errorf("row [%d]: %w", propertyID, ErrNotFound)
+	}
+	return sink.memorySink.Delete(propertyID)
+}
+
+func TestSyncerDoesNotSkipSinkErrors(t *testing.T) {
+	server := newSyncStub(changedPropertiesBody("1:updated", "4:deleted"))
+	defer server.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL))
+	storage := NewFileHighWaterMarkStorage(filepath.Join(t.TempDir(), "mark"))
+	result, err := NewSyncer(api, &notFoundSink{newMemorySink()}, storage).SetStartTime(time.Now().Add(-time.Hour)).Sync(context.Background())
+	var syncError *SyncError
+	if !errors.As(err, &syncError) || len(syncError.Outcomes) != 1 || syncError.Outcomes[0].PropertyID != 4 {
+		t.Fatalf("Expected the sink's failure to delete property [4] to fail the sync but found [%v]", err)
+	}
+	if len(result.Skipped()) != 0 {
+		t.Errorf("Expected no skipped property but found [%+v]", result.Skipped())
+	}
+	if mark, err := storage.LoadHighWaterMark(); err != nil || !mark.IsZero() {
+		t.Errorf("Expected no high-water mark but found [%s] [%v]", mark, err)
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// tokenCache holds the Api's current token in memory so concurrent requests
+// share one token instead of reloading it from TokenStorage on every call.
+// When the token is missing, expired or rejected a single caller renews it using
+// basic authentication while the others wait for the outcome. Once the token is
+// about to expire a single caller renews it early while the others keep using it.
+type tokenCache struct {
+	mutex       sync.Mutex
+	storage     TokenStorage
+	token       *Token
+	loaded      bool
+	renewing    chan struct{}
+	renewBefore time.Duration
+	// renewingEarly is the token being renewed ahead of its expiry
+	renewingEarly string
+	// keepUntilExpiry is a token the API refused to renew early, used until it expires
+	keepUntilExpiry string
+	// rejected is the last token the API rejected, ignored when found in storage
+	rejected string
+	// discarded is told about a stored token ignored because it failed authentication or cannot be read
+	discarded func(ctx context.Context, err error)
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{renewBefore: DefaultTokenRenewBefore}
+}
+
+// setStorage replaces the backing TokenStorage and forgets the cached token
+func (cache *tokenCache) setStorage(storage TokenStorage) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.storage = storage
+	cache.token = nil
+	cache.loaded = false
+}
+
+// acquire returns the token to authenticate the next request with. If no valid
+// token is held, or the token expires within renewBefore, and nobody is renewing
+// one, renew is true and the caller must authenticate with basic auth and call
+// release once the response is handled. Without a valid token acquire otherwise
+// blocks until the running renewal finishes or ctx is done.
+func (cache *tokenCache) acquire(ctx context.Context) (token Token, renew bool, err error) {
+	for {
+		cache.mutex.Lock()
+		if !cache.loaded {
+			if err = cache.load(ctx); err != nil {
+				cache.mutex.Unlock()
+				return Token{}, false, err
+			}
+		}
+		if cache.token != nil && cache.token.IsValid() {
+			if cache.renewing == nil && cache.shouldRenewEarly() {
+				cache.renewing = make(chan struct{})
+				cache.renewingEarly = cache.token.tokenString
+				cache.mutex.Unlock()
+				return Token{}, true, nil
+			}
+			token = *cache.token
+			cache.mutex.Unlock()
+			return token, false, nil
+		}
+		if cache.renewing == nil {
+			cache.renewing = make(chan struct{})
+			cache.mutex.Unlock()
+			return Token{}, true, nil
+		}
+		renewing := cache.renewing
+		cache.mutex.Unlock()
+
+		select {
+		case <-renewing:
+		case <-ctx.Done():
+			return Token{}, false, ctx.Err()
+		}
+	}
+}
+
+// shouldRenewEarly must be called with the mutex held
+func (cache *tokenCache) shouldRenewEarly() bool {
+	return cache.token.expiresWithin(cache.renewBefore) && cache.token.tokenString != cache.keepUntilExpiry
+}
+
+// load must be called with the mutex held. A stored token failing
+// authentication, e.g. after the file was encrypted with another key, or that
+// cannot be read is treated as missing, so it is renewed and the storage overwritten.
+func (cache *tokenCache) load(ctx context.Context) (err error) {
+	if cache.storage != nil {
+		cache.token, err = cache.storage.Load()
+		if errors.Is(err, ErrTokenTampered) || errors.Is(err, ErrTokenUnreadable) {
+			if cache.discarded != nil {
+				cache.discarded(ctx, err)
+			}
+			cache.token, err = nil, nil
+		}
+		if err != nil {
+			return err
+		}
+		if cache.token != nil && cache.token.tokenString == cache.rejected {
+			cache.token.Invalidate()
+		}
+	}
+	cache.loaded = true
+	return nil
+}
+
+// release ends a renewal started by acquire and wakes the waiting callers
+func (cache *tokenCache) release() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.renewingEarly != "" {
+		// the API did not hand out a new token, keep the current one instead of asking again
+		if cache.token != nil && cache.token.tokenString == cache.renewingEarly {
+			cache.keepUntilExpiry = cache.renewingEarly
+		}
+		cache.renewingEarly = ""
+	}
+	if cache.renewing != nil {
+		close(cache.renewing)
+		cache.renewing = nil
+	}
+}
+
+// store caches a token handed out by the API and persists it to the TokenStorage
+func (cache *tokenCache) store(token *Token) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.token = token
+	cache.loaded = true
+	if cache.storage != nil {
+		return cache.storage.Save(*token)
+	}
+	return nil
+}
+
+// invalidate drops the cached token after the API rejected token. It does nothing
+// if the cache has moved on to a newer token in the meantime. The token is then
+// reloaded from storage, which may hold a newer token saved by another process.
+func (cache *tokenCache) invalidate(token Token) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.token != nil && cache.token.tokenString == token.tokenString {
+		cache.token.Invalidate()
+		cache.rejected = token.tokenString
+		cache.loaded = cache.storage == nil
+	}
+}
@@ -0,0 +1,328 @@
+package api
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubVebra imitates the token handling of the Vebra API: basic auth is answered
+// with a fresh token, which must be sent base64 encoded on subsequent requests.
+type stubVebra struct {
+	*httptest.Server
+	mutex      sync.Mutex
+	token      string
+	generation int
+	basicAuths int32
+	requests   int32
+	body       string
+	// refuseRenewal makes basic auth fail while a token is active
+	refuseRenewal bool
+}
+
+func newStubVebra(body string) *stubVebra {
+	stub := &stubVebra{body: body}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.handle))
+	return stub
+}
+
+func (stub *stubVebra) handle(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&stub.requests, 1)
+	if user, password, ok := r.BasicAuth(); ok && user == "user" && password == "password" {
+		atomic.AddInt32(&stub.basicAuths, 1)
+		stub.mutex.Lock()
+		if stub.refuseRenewal && stub.token != "" {
+			stub.mutex.Unlock()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		stub.generation++
+		stub.token = fmt.Sprintf("token-%d", stub.generation)
+		w.Header().Set(HeaderTokenKey, stub.token)
+		stub.mutex.Unlock()
+		w.Write([]byte(stub.body))
+		return
+	}
+	stub.mutex.Lock()
+	token := stub.token
+	stub.mutex.Unlock()
+	if token == "" || r.Header.Get(HeaderAuthorizationKey) != "Basic "+base64.StdEncoding.EncodeToString([]byte(token)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	w.Write([]byte(stub.body))
+}
+
+// expireToken makes the API reject the current token
+func (stub *stubVebra) expireToken() {
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stub.token = ""
+}
+
+type memoryTokenStorage struct {
+	mutex sync.Mutex
+	token *Token
+	saves int
+}
+
+func (ts *memoryTokenStorage) Save(token Token) error {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	ts.token = &token
+	ts.saves++
+	return nil
+}
+
+func (ts *memoryTokenStorage) Load() (*Token, error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+	if ts.token == nil {
+		return &Token{}, nil
+	}
+	token := *ts.token
+	return &token, nil
+}
+
+func getPropertiesConcurrently(api *Api, goroutines int) []error {
+	var wg sync.WaitGroup
+	errs := make([]error, goroutines)
+	branch := &BranchSummary{Url: "http://webservices.vebra.com/export/ABCDEFG/v10/branch/1234"}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = api.GetProperty(branch, PropertySummary{PropertyID: uint(i)})
+		}(i)
+	}
+	wg.Wait()
+	return errs
+}
+
+func TestApiConcurrentRequestsShareOneToken(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+
+	storage := &memoryTokenStorage{}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+
+	for i, err := range getPropertiesConcurrently(api, 50) {
+		if err != nil {
+			t.Errorf("Request [%d] failed: %s", i, err)
+		}
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 1 {
+		t.Errorf("Expected [1] basic auth request but found [%d]", basicAuths)
+	}
+	if storage.saves != 1 || storage.token.GetToken() != "token-1" {
+		t.Errorf("Expected token-1 to be saved once but found [%d] saves of [%+v]", storage.saves, storage.token)
+	}
+}
+
+func TestApiConcurrentRequestsRenewRejectedTokenOnce(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	stub.expireToken()
+
+	for i, err := range getPropertiesConcurrently(api, 50) {
+		if err != nil {
+			t.Errorf("Request [%d] failed: %s", i, err)
+		}
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 2 {
+		t.Errorf("Expected [2] basic auth requests but found [%d]", basicAuths)
+	}
+}
+
+func TestApiReusesStoredToken(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "stored-token"
+
+	storage := &memoryTokenStorage{token: NewToken("stored-token")}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+
+	for i, err := range getPropertiesConcurrently(api, 20) {
+		if err != nil {
+			t.Errorf("Request [%d] failed: %s", i, err)
+		}
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 0 {
+		t.Errorf("Expected the stored token to be reused but found [%d] basic auth requests", basicAuths)
+	}
+}
+
+func TestTokenExpiry(t *testing.T) {
+	token := NewToken("token")
+	if expected := token.AcquiredAt().Add(TokenLifetime); !token.ExpiresAt().Equal(expected) {
+		t.Errorf("Expected expiry [%s] but found [%s]", expected, token.ExpiresAt())
+	}
+	if token.Age() < 0 || token.Age() > time.Minute {
+		t.Errorf("Expected a new token but found age [%s]", token.Age())
+	}
+	if !token.IsValid() || token.IsExpired() {
+		t.Errorf("Expected a new token to be valid")
+	}
+
+	expired := RestoreToken("token", time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+	if expired.IsValid() || !expired.IsExpired() {
+		t.Errorf("Expected a token past its expiry to be invalid")
+	}
+	if expired.Age() < 2*time.Hour {
+		t.Errorf("Expected age of at least [2h] but found [%s]", expired.Age())
+	}
+
+	unknown := RestoreToken("token", time.Time{}, time.Time{})
+	if !unknown.IsValid() || unknown.Age() != 0 {
+		t.Errorf("Expected a token without expiry to be valid")
+	}
+	if empty := RestoreToken("", time.Now(), time.Now().Add(TokenLifetime)); empty.IsValid() {
+		t.Errorf("Expected an empty token to be invalid")
+	}
+}
+
+func TestApiRenewsExpiredStoredToken(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "stored-token"
+
+	acquiredAt := time.Now().Add(-TokenLifetime - time.Minute)
+	storage := &memoryTokenStorage{token: RestoreToken("stored-token", acquiredAt, acquiredAt.Add(TokenLifetime))}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 1 {
+		t.Errorf("Expected the expired token to be renewed but found [%d] basic auth requests", basicAuths)
+	}
+	if storage.token.GetToken() != "token-1" || !storage.token.ExpiresAt().After(time.Now()) {
+		t.Errorf("Expected token-1 to be saved with its expiry but found [%+v]", storage.token)
+	}
+}
+
+func TestApiRenewsTokenBeforeExpiry(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "stored-token"
+
+	expiresAt := time.Now().Add(time.Minute)
+	storage := &memoryTokenStorage{token: RestoreToken("stored-token", expiresAt.Add(-TokenLifetime), expiresAt)}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+
+	for i, err := range getPropertiesConcurrently(api, 50) {
+		if err != nil {
+			t.Errorf("Request [%d] failed: %s", i, err)
+		}
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 1 {
+		t.Errorf("Expected [1] basic auth request but found [%d]", basicAuths)
+	}
+	if storage.token.GetToken() != "token-1" {
+		t.Errorf("Expected token-1 to be saved but found [%+v]", storage.token)
+	}
+}
+
+func TestApiKeepsTokenWhenEarlyRenewalIsRefused(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "stored-token"
+	stub.refuseRenewal = true
+
+	expiresAt := time.Now().Add(time.Minute)
+	storage := &memoryTokenStorage{token: RestoreToken("stored-token", expiresAt.Add(-TokenLifetime), expiresAt)}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+
+	for round := 0; round < 2; round++ {
+		for i, err := range getPropertiesConcurrently(api, 20) {
+			if err != nil {
+				t.Errorf("Request [%d] failed: %s", i, err)
+			}
+		}
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 1 {
+		t.Errorf("Expected a single attempt to renew early but found [%d] basic auth requests", basicAuths)
+	}
+}
+
+func TestApiTokenRenewBeforeZeroKeepsToken(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "stored-token"
+
+	expiresAt := time.Now().Add(time.Minute)
+	storage := &memoryTokenStorage{token: RestoreToken("stored-token", expiresAt.Add(-TokenLifetime), expiresAt)}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithTokenRenewBefore(0))
+	api.SetTokenStorage(storage)
+
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 0 {
+		t.Errorf("Expected the token to be kept until it expires but found [%d] basic auth requests", basicAuths)
+	}
+}
+
+func TestApiReloadsTokenSavedByAnotherProcess(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+	stub.token = "token-a"
+
+	storage := &memoryTokenStorage{token: NewToken("token-a")}
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+
+	// another process renews the token, invalidating the one held by api
+	stub.mutex.Lock()
+	stub.token = "token-b"
+	stub.mutex.Unlock()
+	storage.Save(*NewToken("token-b"))
+
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 0 {
+		t.Errorf("Expected the stored token to be picked up but found [%d] basic auth requests", basicAuths)
+	}
+}
+
+func TestApiReusesTokenSavedToFile(t *testing.T) {
+	stub := newStubVebra(`<property id="1"></property>`)
+	defer stub.Close()
+
+	storage := new(FileTokenStorage)
+	storage.SetFileName(t.TempDir() + "/token")
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new client must send the token read back from the file as the API handed it out
+	api = NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL))
+	api.SetTokenStorage(storage)
+	if _, err := api.GetBranches(); err != nil {
+		t.Fatal(err)
+	}
+	if basicAuths := atomic.LoadInt32(&stub.basicAuths); basicAuths != 1 {
+		t.Errorf("Expected the saved token to be reused but found [%d] basic auth requests", basicAuths)
+	}
+}
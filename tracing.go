@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Attributes set on spans
+const (
+	AttributeURL          = AttributeKey("url.full")
+	AttributeStatusCode   = AttributeKey("http.response.status_code")
+	AttributePropertyID   = AttributeKey("vebra.property_id")
+	AttributeBranchClient = AttributeKey("vebra.branch_client_id")
+	AttributeNotModified  = AttributeKey("vebra.not_modified")
+	AttributeFromCache    = AttributeKey("vebra.from_cache")
+	AttributeBytes        = AttributeKey("vebra.bytes")
+)
+
+// Tracer starts the spans of Api calls and media downloads. The otel subpackage
+// adapts an OpenTelemetry TracerProvider, keeping OpenTelemetry out of this package.
+type Tracer interface {
+	// Start starts a span, child of the span in ctx, and returns a context holding it
+	Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span)
+}
+
+// Span is a traced operation started by a Tracer
+type Span interface {
+	SetAttributes(attributes ...Attribute)
+	// End ends the span, marking it as failed if err is not nil
+	End(err error)
+}
+
+// AttributeKey names an Attribute
+type AttributeKey string
+
+// Attribute describes a span
+// Contains:
+// Key: The name of the attribute
+// Value: The value of the attribute, a string, an int64 or a bool
+type Attribute struct {
+	Key   AttributeKey
+	Value interface{}
+}
+
+// String returns an attribute holding a string
+func (key AttributeKey) String(value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int returns an attribute holding an int, stored as an int64
+func (key AttributeKey) Int(value int) Attribute {
+	return Attribute{Key: key, Value: int64(value)}
+}
+
+// Int64 returns an attribute holding an int64
+func (key AttributeKey) Int64(value int64) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Bool returns an attribute holding a bool
+func (key AttributeKey) Bool(value bool) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// WithTracer creates a span for every Api call, child of the span in the call's
+// context, and for decoding its response
+func WithTracer(tracer Tracer) Option {
+	return func(api *Api) {
+		api.tracer = tracer
+	}
+}
+
+// SetTracer creates a span for every property whose media is downloaded, with
+// children for each download and thumbnail. Spans are children of the span in
+// the context passed to DownloadContext.
+func (fileDownloader *fileDownloader) SetTracer(tracer Tracer) {
+	fileDownloader.tracer = tracer
+}
+
+// noopSpan is the span started without a Tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+
+func (noopSpan) End(error) {}
+
+// startSpan starts a span with tracer, which may be nil to trace nothing
+func startSpan(ctx context.Context, tracer Tracer, name string, attributes ...Attribute) (context.Context, Span) {
+	if tracer == nil {
+		return ctx, noopSpan{}
+	}
+	return tracer.Start(ctx, name, attributes...)
+}
+
+// urlAttributes describes an API URL, including the branch client ID and
+// property ID found in its path. A number followed by another number is part
+// of a date, as in property/2019/01/02/03/04/05, not an ID.
+func urlAttributes(rawURL string) []Attribute {
+	attributes := []Attribute{AttributeURL.String(redactURL(rawURL))}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return attributes
+	}
+	segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		id, err := strconv.Atoi(segments[i+1])
+		if err != nil {
+			continue
+		}
+		if i+2 < len(segments) {
+			if _, err := strconv.Atoi(segments[i+2]); err == nil {
+				continue
+			}
+		}
+		switch segments[i] {
+		case "branch":
+			attributes = append(attributes, AttributeBranchClient.Int(id))
+		case "property":
+			attributes = append(attributes, AttributePropertyID.Int(id))
+		}
+	}
+	return attributes
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// recordedSpan is a span started by a recordingTracer
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	attributes map[AttributeKey]interface{}
+	err        error
+}
+
+func (span *recordedSpan) SetAttributes(attributes ...Attribute) {
+	for _, attribute := range attributes {
+		span.attributes[attribute.Key] = attribute.Value
+	}
+}
+
+func (span *recordedSpan) End(err error) {
+	span.err = err
+}
+
+type recordedSpanKey struct{}
+
+// recordingTracer records the spans it starts, parented by the span in their context
+type recordingTracer struct {
+	mutex sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tracer *recordingTracer) Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, Span) {
+	span := &recordedSpan{name: name, attributes: make(map[AttributeKey]interface{})}
+	span.parent, _ = ctx.Value(recordedSpanKey{}).(*recordedSpan)
+	span.SetAttributes(attributes...)
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	tracer.spans = append(tracer.spans, span)
+	return context.WithValue(ctx, recordedSpanKey{}, span), span
+}
+
+// findSpan returns the span with the given name
+func (tracer *recordingTracer) findSpan(t *testing.T, name string) *recordedSpan {
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	for _, span := range tracer.spans {
+		if span.name == name {
+			return span
+		}
+	}
+	t.Fatalf("Expected a span [%s]", name)
+	return nil
+}
+
+func TestApiTracesRequests(t *testing.T) {
+	stub := newStubVebra(`<property id="26858499"></property>`)
+	defer stub.Close()
+
+	tracer := new(recordingTracer)
+	api := NewApi("ABCDEFG", "user", "password", WithBaseURL(stub.URL), WithTracer(tracer))
+
+	ctx, parent := tracer.Start(context.Background(), "sync")
+	branch := &BranchSummary{Url: stub.URL + "/branch/3741"}
+	if _, err := api.GetPropertyContext(ctx, branch, PropertySummary{PropertyID: 26858499}); err != nil {
+		t.Fatal(err)
+	}
+	parent.End(nil)
+
+	request := tracer.findSpan(t, "vebra branch/property")
+	if request.parent != parent {
+		t.Errorf("Expected the request span to be a child of [sync] but found [%+v]", request.parent)
+	}
+	if id := request.attributes[AttributeBranchClient]; id != int64(3741) {
+		t.Errorf("Expected branch client ID [3741] but found [%v]", id)
+	}
+	if id := request.attributes[AttributePropertyID]; id != int64(26858499) {
+		t.Errorf("Expected property ID [26858499] but found [%v]", id)
+	}
+	if status := request.attributes[AttributeStatusCode]; status != int64(http.StatusOK) {
+		t.Errorf("Expected status [200] but found [%v]", status)
+	}
+
+	unmarshal := tracer.findSpan(t, "vebra unmarshal")
+	if unmarshal.parent != request {
+		t.Errorf("Expected the unmarshal span to be a child of the request span but found [%+v]", unmarshal.parent)
+	}
+}
+
+func TestUrlAttributesIgnoreDates(t *testing.T) {
+	for _, kv := range urlAttributes("http://webservices.vebra.com/export/ABCDEFG/v10/property/2019/01/02/03/04/05") {
+		if kv.Key == AttributePropertyID {
+			t.Errorf("Expected no property ID but found [%v]", kv.Value)
+		}
+	}
+}
+
+func TestFileDownloaderTracesDownloads(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		png.Encode(w, image.NewRGBA(image.Rect(0, 0, 20, 20)))
+	}))
+	defer server.Close()
+
+	tracer := new(recordingTracer)
+	downloader := FileDownloader(1)
+	downloader.SetOutputDirectory(t.TempDir())
+	downloader.SetMaxHeight(100)
+	downloader.SetTracer(tracer)
+
+	ctx, parent := tracer.Start(context.Background(), "sync")
+	downloader.DownloadContext(ctx, &propertyFilesManifest{"26858499", []string{server.URL + "/image.png"}, nil})
+	downloader.Wait()
+	parent.End(nil)
+
+	property := tracer.findSpan(t, "vebra download property")
+	if property.parent != parent {
+		t.Errorf("Expected the property span to be a child of [sync] but found [%+v]", property.parent)
+	}
+	if id := property.attributes[AttributePropertyID]; id != int64(26858499) {
+		t.Errorf("Expected property ID [26858499] but found [%v]", id)
+	}
+	for _, name := range []string{"vebra download", "vebra resize"} {
+		if span := tracer.findSpan(t, name); span.parent != property {
+			t.Errorf("Expected span [%s] to be a child of the property span", name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// recordedPathPattern matches the part of an API path identifying the feed, which is left out of recordings
+var recordedPathPattern = regexp.MustCompile(`^/export/[^/]+/v10/`)
+
+// NewRemoteFileGetter returns a transport querying the Vebra API. This is the default.
+func NewRemoteFileGetter() http.RoundTripper {
+	return http.DefaultTransport
+}
+
+// NewRemoteFileGetterLocalWriter returns a transport querying the Vebra API which
+// also writes every successful response below directory, mirroring the URL path,
+// e.g. export/{datafeedid}/v10/branch/3741/property/26858499 is written to
+// {directory}/api/branch/3741/property/26858499.xml
+func NewRemoteFileGetterLocalWriter(directory string) http.RoundTripper {
+	return &recordingTransport{
+		next:      http.DefaultTransport,
+		directory: directory,
+	}
+}
+
+// NewLocalFileGetter returns a transport answering requests from the files written
+// by NewRemoteFileGetterLocalWriter, without any network access. Requests for
+// which no file was recorded are answered with 404 Not Found.
+func NewLocalFileGetter(directory string) http.RoundTripper {
+	return &replayingTransport{directory: directory}
+}
+
+// recordedFileName maps a request URL onto the file it is recorded in. URL paths
+// come from the feed, so one resolving outside of the recordings is an error.
+func recordedFileName(directory string, request *http.Request) (string, error) {
+	urlPath := recordedPathPattern.ReplaceAllString(path.Clean(request.URL.Path), "")
+	urlPath = strings.Trim(urlPath, "/")
+	fileName := filepath.Join(directory, "api", filepath.FromSlash(urlPath)+".xml")
+	if !strings.HasPrefix(fileName, filepath.Join(directory, "api")+string(filepath.Separator)) {
+		return "", fmt.Errorf("URL path [%s] resolves outside of recording directory [%s]", request.URL.Path, directory)
+	}
+	return fileName, nil
+}
+
+type recordingTransport struct {
+	next      http.RoundTripper
+	directory string
+}
+
+func (transport *recordingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	fileName, err := recordedFileName(transport.directory, request)
+	if err != nil {
+		if request.Body != nil {
+			request.Body.Close()
+		}
+		return nil, err
+	}
+	response, err := transport.next.RoundTrip(request)
+	if err != nil || response.StatusCode != http.StatusOK {
+		return response, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	if err := os.MkdirAll(filepath.Dir(fileName), os.ModePerm); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(fileName, body, 0644); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+type replayingTransport struct {
+	directory string
+}
+
+func (transport *replayingTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	if request.Body != nil {
+		request.Body.Close()
+	}
+	response := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Request:    request,
+	}
+	fileName, err := recordedFileName(transport.directory, request)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadFile(fileName)
+	switch {
+	case os.IsNotExist(err):
+		response.StatusCode = http.StatusNotFound
+		body = []byte("no recorded response")
+	case err != nil:
+		return nil, err
+	default:
+		response.StatusCode = http.StatusOK
+		response.Header.Set("Content-Type", "application/xml")
+	}
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	response.Body = ioutil.NopCloser(bytes.NewReader(body))
+	response.ContentLength = int64(len(body))
+	return response, nil
+}
@@ -0,0 +1,97 @@
+package api
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<property id="26858499"><address><town>Leeds</town></address></property>`))
+	}))
+	directory := t.TempDir()
+
+	recorder := NewApi("ABCDEFG", "user", "password", WithBaseURL(server.URL), WithTransport(NewRemoteFileGetterLocalWriter(directory)))
+	if _, err := recorder.GetProperty(testBranchSummary, PropertySummary{PropertyID: 26858499}); err != nil {
+		t.Fatal(err)
+	}
+	server.Close()
+
+	recorded, err := ioutil.ReadFile(filepath.Join(directory, "api", "branch", "3741", "property", "26858499.xml"))
+	if err != nil {
+		t.Fatalf("Expected the response to be recorded: %s", err)
+	}
+	if len(recorded) == 0 {
+		t.Error("Expected the recorded response to have a body")
+	}
+
+	replayer := NewApi("ABCDEFG", "user", "password", WithTransport(NewLocalFileGetter(directory)))
+	property, err := replayer.GetProperty(testBranchSummary, PropertySummary{PropertyID: 26858499})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if property.ID != 26858499 || property.Address.Town != "Leeds" {
+		t.Errorf("Unexpected replayed property [%d] in [%s]", property.ID, property.Address.Town)
+	}
+
+	if _, err := replayer.GetProperty(testBranchSummary, PropertySummary{PropertyID: 1}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected [%v] for a response that was never recorded but found [%v]", ErrNotFound, err)
+	}
+}
+
+func TestRecordedFileName(t *testing.T) {
+	request := httptest.NewRequest(http.MethodGet, "http://webservices.vebra.com/export/ABCDEFG/v10/property/2017/03/21/13/39/33", nil)
+	expected := filepath.Join("test_assets", "api", "property", "2017", "03", "21", "13", "39", "33.xml")
+	if actual, err := recordedFileName("test_assets", request); err != nil || actual != expected {
+		t.Errorf("Expected [%s] but found [%s] [%v]", expected, actual, err)
+	}
+}
+
+func TestRecordedFileNameStaysInDirectory(t *testing.T) {
+	directory := t.TempDir()
+	request := httptest.NewRequest(http.MethodGet, "http://webservices.vebra.com/export/X/v10/../../../../etc/cron.d/x", nil)
+	expected := filepath.Join(directory, "api", "etc", "cron.d", "x.xml")
+	if actual, err := recordedFileName(directory, request); err != nil || actual != expected {
+		t.Errorf("Expected [%s] but found [%s] [%v]", expected, actual, err)
+	}
+
+	request = &http.Request{Method: http.MethodGet, URL: &url.URL{Path: "../../etc/cron.d/x"}}
+	answering := new(answeringTransport)
+	for name, transport := range map[string]http.RoundTripper{
+		"recording": &recordingTransport{next: answering, directory: directory},
+		"replaying": NewLocalFileGetter(directory),
+	} {
+		if response, err := transport.RoundTrip(request); err == nil {
+			t.Errorf("[%s] Expected an error for a path outside of the directory but found [%s]", name, response.Status)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(directory, "..", "etc")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside of the directory but found [%v]", err)
+	}
+	if answering.requests != 0 {
+		t.Errorf("Expected the request not to be sent but found [%d] requests", answering.requests)
+	}
+}
+
+// answeringTransport answers every request with an empty property, whatever its URL
+type answeringTransport struct {
+	requests int
+}
+
+func (transport *answeringTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	transport.requests++
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Status:     "200 OK",
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(strings.NewReader(`<property></property>`)),
+		Request:    request,
+	}, nil
+}